@@ -2,19 +2,34 @@ package bucket
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/tiredkangaroo/ajiteshcc/env"
 )
 
 var S3Client *s3.Client
 
+// transport is S3Client's connection pool, kept so Close can release it.
+var transport *http.Transport
+
+// errors
+var ErrObjectExists = errors.New("object already exists")
+var ErrObjectNotFound = errors.New("object not found")
+
 func Init() error {
 	cfg, err := config.LoadDefaultConfig(
 		context.Background(),
@@ -30,19 +45,35 @@ func Init() error {
 	if err != nil {
 		return err
 	}
+	// the client is frozen so Close can get to the transport its connections are pooled in
+	if b, ok := cfg.HTTPClient.(*awshttp.BuildableClient); ok {
+		client := b.Freeze().(*http.Client)
+		transport, _ = client.Transport.(*http.Transport)
+		cfg.HTTPClient = client
+	}
 	S3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", env.DefaultEnv.R2_ACCOUNT_ID))
 	})
 	return nil
 }
 
+// Close closes the bucket's idle connections. it's called on shutdown, once nothing uses the
+// bucket anymore.
+func Close() {
+	if transport != nil {
+		transport.CloseIdleConnections()
+	}
+}
+
 type Object struct {
-	Name      string            `json:"name"`       // object key
-	Size      int64             `json:"size"`       // size in bytes
-	PublicURL string            `json:"public_url"` // public URL of the object
-	Metadata  map[string]string `json:"metadata"`   // metadata associated with the object
+	Name         string            `json:"name"`          // object key
+	Size         int64             `json:"size"`          // size in bytes
+	PublicURL    string            `json:"public_url"`    // public URL of the object
+	Metadata     map[string]string `json:"metadata"`      // metadata associated with the object
+	LastModified time.Time         `json:"last_modified"` // when the object was last written (for trashed objects, when it was trashed)
 }
 
+// ListAllObjectsInBucket lists every object in the bucket except trashed ones.
 func ListAllObjectsInBucket(ctx context.Context, bucketName string) ([]Object, error) {
 	output, err := S3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 		Bucket: &bucketName,
@@ -50,34 +81,120 @@ func ListAllObjectsInBucket(ctx context.Context, bucketName string) ([]Object, e
 	if err != nil {
 		return nil, fmt.Errorf("list objects in bucket: %w", err)
 	}
-	objects := make([]Object, len(output.Contents))
-	pubURL := *env.DefaultEnv.R2_PHOTOS_BUCKET_PUBLIC_URL
-	for i, obj := range output.Contents {
+	objects := make([]Object, 0, len(output.Contents))
+	for _, obj := range output.Contents {
+		if IsTrashKey(*obj.Key) {
+			continue
+		}
 		md, err := GetObjectMetadata(ctx, bucketName, *obj.Key)
 		if err != nil {
 			return nil, fmt.Errorf("get object metadata for %s: %w", *obj.Key, err)
 		}
-		pubURL.Path = "/" + *obj.Key
-		objects[i] = Object{
-			Name:      *obj.Key,
-			Size:      *obj.Size,
-			PublicURL: pubURL.String(),
-			Metadata:  md,
-		}
+		objects = append(objects, Object{
+			Name:         *obj.Key,
+			Size:         *obj.Size,
+			PublicURL:    PublicURL(*obj.Key),
+			Metadata:     md,
+			LastModified: aws.ToTime(obj.LastModified),
+		})
 	}
 	return objects, nil
 }
 
-func PutObjectInBucket(ctx context.Context, bucketName, objectKey string, metadata map[string]string, body io.Reader) error {
+// ListObjectKeys lists the keys of every object whose key starts with prefix, except trashed ones.
+// unlike ListAllObjectsInBucket it doesn't fetch metadata, so it's cheap for large buckets.
+func ListObjectKeys(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(S3Client, &s3.ListObjectsV2Input{
+		Bucket: &bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list objects with prefix %s: %w", prefix, err)
+		}
+		for _, obj := range output.Contents {
+			if !IsTrashKey(*obj.Key) {
+				keys = append(keys, *obj.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// PublicURL returns the public URL of an object in the photos bucket. this is the URL stored in
+// photos.photo_url.
+func PublicURL(objectKey string) string {
+	pubURL := *env.DefaultEnv.R2_PHOTOS_BUCKET_PUBLIC_URL
+	pubURL.Path = "/" + objectKey
+	return pubURL.String()
+}
+
+// ObjectHeaders are the HTTP headers an object is served with. empty fields are left unset.
+type ObjectHeaders struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+}
+
+func PutObjectInBucket(ctx context.Context, bucketName, objectKey string, metadata map[string]string, headers ObjectHeaders, body io.Reader) error {
+	_, err := S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		Body:               body,
+		Metadata:           encodeMetadata(metadata),
+		ContentType:        optional(headers.ContentType),
+		CacheControl:       optional(headers.CacheControl),
+		ContentDisposition: optional(headers.ContentDisposition),
+	})
+	return err
+}
+
+// PutNewObjectInBucket is like PutObjectInBucket but fails with ErrObjectExists instead of
+// overwriting an existing object (If-None-Match: *).
+func PutNewObjectInBucket(ctx context.Context, bucketName, objectKey string, metadata map[string]string, headers ObjectHeaders, body io.Reader) error {
 	_, err := S3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:   &bucketName,
-		Key:      &objectKey,
-		Body:     body,
-		Metadata: metadata,
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		Body:               body,
+		Metadata:           encodeMetadata(metadata),
+		ContentType:        optional(headers.ContentType),
+		CacheControl:       optional(headers.CacheControl),
+		ContentDisposition: optional(headers.ContentDisposition),
+		IfNoneMatch:        aws.String("*"),
 	})
+	if isHTTPStatus(err, http.StatusPreconditionFailed) {
+		return ErrObjectExists
+	}
 	return err
 }
 
+func ObjectExists(ctx context.Context, bucketName, objectKey string) (bool, error) {
+	_, err := S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err == nil {
+		return true, nil
+	} else if isHTTPStatus(err, http.StatusNotFound) {
+		return false, nil
+	}
+	return false, fmt.Errorf("head object %s: %w", objectKey, err)
+}
+
+// ObjectETag returns an object's ETag (without quotes) and size. see ContentETag.
+func ObjectETag(ctx context.Context, bucketName, objectKey string) (string, int64, error) {
+	headOutput, err := S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("head object %s: %w", objectKey, err)
+	}
+	return strings.Trim(aws.ToString(headOutput.ETag), `"`), aws.ToInt64(headOutput.ContentLength), nil
+}
+
 func GetObjectMetadata(ctx context.Context, bucketName, objectKey string) (map[string]string, error) {
 	headOutput, err := S3Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &bucketName,
@@ -86,16 +203,150 @@ func GetObjectMetadata(ctx context.Context, bucketName, objectKey string) (map[s
 	if err != nil {
 		return nil, fmt.Errorf("head object %s: %w", objectKey, err)
 	}
-	return headOutput.Metadata, nil
+	return decodeMetadata(headOutput.Metadata), nil
 }
 
+// UpdateObjectMetadata replaces an object's metadata, keeping the headers it's served with.
 func UpdateObjectMetadata(ctx context.Context, bucketName, objectKey string, newMetadata map[string]string) error {
+	headOutput, err := S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return fmt.Errorf("head object %s: %w", objectKey, err)
+	}
+	return ReplaceObjectMetadata(ctx, bucketName, objectKey, newMetadata, ObjectHeaders{
+		ContentType:        aws.ToString(headOutput.ContentType),
+		CacheControl:       aws.ToString(headOutput.CacheControl),
+		ContentDisposition: aws.ToString(headOutput.ContentDisposition),
+	})
+}
+
+// ReplaceObjectMetadata replaces both an object's metadata and its headers. headers that are
+// left empty are removed from the object.
+func ReplaceObjectMetadata(ctx context.Context, bucketName, objectKey string, newMetadata map[string]string, headers ObjectHeaders) error {
 	_, err := S3Client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:            &bucketName,
-		Key:               &objectKey,
-		CopySource:        aws.String(bucketName + "/" + objectKey),
-		Metadata:          newMetadata,
-		MetadataDirective: types.MetadataDirectiveReplace,
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		CopySource:         copySource(bucketName, objectKey),
+		Metadata:           encodeMetadata(newMetadata),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        optional(headers.ContentType),
+		CacheControl:       optional(headers.CacheControl),
+		ContentDisposition: optional(headers.ContentDisposition),
 	})
 	return err
 }
+
+// GetObjectRange reads the first n bytes of an object. it is used to pull metadata out of
+// large objects without downloading them entirely.
+func GetObjectRange(ctx context.Context, bucketName, objectKey string, n int64) ([]byte, error) {
+	output, err := S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get object %s: %w", objectKey, err)
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+func DeleteObject(ctx context.Context, bucketName, objectKey string) error {
+	_, err := S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return fmt.Errorf("delete object %s: %w", objectKey, err)
+	}
+	return nil
+}
+
+// CopyObject copies an object (including its metadata) to a new key in the same bucket.
+func CopyObject(ctx context.Context, bucketName, srcKey, dstKey string) error {
+	_, err := S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &bucketName,
+		Key:        &dstKey,
+		CopySource: copySource(bucketName, srcKey),
+	})
+	if isHTTPStatus(err, http.StatusNotFound) {
+		return ErrObjectNotFound
+	} else if err != nil {
+		return fmt.Errorf("copy object %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// CopyNewObject is like CopyObject but fails with ErrObjectExists instead of overwriting an existing
+// object at dstKey. CopyObject has no If-None-Match in S3, so this uses R2's equivalent header.
+func CopyNewObject(ctx context.Context, bucketName, srcKey, dstKey string) error {
+	_, err := S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &bucketName,
+		Key:        &dstKey,
+		CopySource: copySource(bucketName, srcKey),
+	}, s3.WithAPIOptions(smithyhttp.AddHeaderValue("cf-copy-destination-if-none-match", "*")))
+	if isHTTPStatus(err, http.StatusNotFound) {
+		return ErrObjectNotFound
+	} else if isHTTPStatus(err, http.StatusPreconditionFailed) {
+		return ErrObjectExists
+	} else if err != nil {
+		return fmt.Errorf("copy object %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// MoveObject moves an object to a new key. buckets can't rename, so this is a copy followed by
+// a delete of the original.
+func MoveObject(ctx context.Context, bucketName, srcKey, dstKey string) error {
+	if err := CopyObject(ctx, bucketName, srcKey, dstKey); err != nil {
+		return err
+	}
+	return DeleteObject(ctx, bucketName, srcKey)
+}
+
+// copySource builds the (url-encoded) CopySource for an object.
+func copySource(bucketName, objectKey string) *string {
+	segments := strings.Split(objectKey, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return aws.String(bucketName + "/" + strings.Join(segments, "/"))
+}
+
+// encodeMetadata RFC 2047 encodes non-ASCII metadata values (e.g. captions and keywords), since
+// metadata is sent as HTTP headers.
+func encodeMetadata(md map[string]string) map[string]string {
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		out[k] = mime.QEncoding.Encode("utf-8", v)
+	}
+	return out
+}
+
+// decodeMetadata reverses encodeMetadata. values that aren't encoded are returned as they are.
+func decodeMetadata(md map[string]string) map[string]string {
+	var dec mime.WordDecoder
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		if decoded, err := dec.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// optional returns nil for empty strings so unset headers aren't sent.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func isHTTPStatus(err error, status int) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == status
+}
@@ -0,0 +1,164 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// errors
+var ErrInvalidKey = errors.New("invalid object key")
+
+// maximum length of an object key in bytes (S3 and R2 limit)
+const maxKeyLength = 1024
+
+// maximum number of suffixes tried by CollisionAutoSuffix before giving up
+const maxAutoSuffix = 100
+
+// CollisionPolicy decides what happens when an upload targets a key that already exists.
+type CollisionPolicy string
+
+const (
+	CollisionReject     CollisionPolicy = "reject"                 // fail the upload
+	CollisionAutoSuffix CollisionPolicy = "auto-suffix"            // upload to name-1.ext, name-2.ext, ...
+	CollisionOverwrite  CollisionPolicy = "overwrite-with-confirm" // overwrite only if the request confirms it
+)
+
+func ParseCollisionPolicy(s string) (CollisionPolicy, error) {
+	switch p := CollisionPolicy(s); p {
+	case CollisionReject, CollisionAutoSuffix, CollisionOverwrite:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown collision policy %q", s)
+}
+
+// canonical spellings of common photo extensions
+var extAliases = map[string]string{
+	".jpeg": ".jpg",
+	".jpe":  ".jpg",
+	".tif":  ".tiff",
+	".heif": ".heic",
+}
+
+// NormalizeKey turns a user-provided name into a safe object key. it applies unicode NFC
+// normalization, strips diacritics, lowercases, turns backslashes into slashes, drops empty,
+// "." and ".." segments, replaces anything that isn't a letter, digit, '.', '_' or '-' with '-'
+// and canonicalizes the extension (e.g. ".JPEG" -> ".jpg").
+func NormalizeKey(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	var segments []string
+	for seg := range strings.SplitSeq(name, "/") {
+		if seg = normalizeSegment(seg); seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	if len(segments) == 0 {
+		return "", ErrInvalidKey
+	}
+	key := strings.Join(segments, "/")
+	ext := path.Ext(key)
+	key = strings.TrimSuffix(key, ext) + normalizeExt(ext)
+	if len(key) > maxKeyLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidKey, maxKeyLength)
+	} else if IsTrashKey(key) {
+		return "", fmt.Errorf("%w: %s is reserved for the trash", ErrInvalidKey, TrashPrefix)
+	}
+	return key, nil
+}
+
+func normalizeSegment(seg string) string {
+	var b strings.Builder
+	// decompose so diacritics become separate marks we can drop
+	for _, r := range norm.NFD.String(seg) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case r == '.' || r == '_' || r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	out := norm.NFC.String(b.String())
+	for strings.Contains(out, "--") {
+		out = strings.ReplaceAll(out, "--", "-")
+	}
+	// leading/trailing dots would allow "..", hidden files and extension-only names
+	return strings.Trim(out, "-.")
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if alias, ok := extAliases[ext]; ok {
+		return alias
+	}
+	return ext
+}
+
+// ApplyKeyTemplate builds an object key from a template and normalizes it. supported placeholders:
+//
+//	{name}  the name without its extension
+//	{ext}   the extension, including the dot
+//	{hash}  the content hash (must be provided if used)
+//	{yyyy}, {mm}, {dd}  the date t
+//
+// e.g. "{yyyy}/{mm}/{name}{ext}" or "{hash}{ext}".
+func ApplyKeyTemplate(tmpl, name, hash string, t time.Time) (string, error) {
+	if strings.Contains(tmpl, "{hash}") && hash == "" {
+		return "", fmt.Errorf("%w: template requires a content hash", ErrInvalidKey)
+	}
+	ext := path.Ext(name)
+	key := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+		"{hash}", hash,
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+	).Replace(tmpl)
+	return NormalizeKey(key)
+}
+
+// ResolveObjectKey applies a collision policy to key. it returns the key to upload to and whether
+// the upload is allowed to overwrite an existing object. uploads that must not overwrite should use
+// If-None-Match so a race between this check and the upload still fails with ErrObjectExists.
+func ResolveObjectKey(ctx context.Context, bucketName, key string, policy CollisionPolicy, confirmOverwrite bool) (string, bool, error) {
+	exists, err := ObjectExists(ctx, bucketName, key)
+	if err != nil {
+		return "", false, err
+	}
+	if !exists {
+		return key, false, nil
+	}
+	switch policy {
+	case CollisionOverwrite:
+		if confirmOverwrite {
+			return key, true, nil
+		}
+		return "", false, ErrObjectExists
+	case CollisionAutoSuffix:
+		ext := path.Ext(key)
+		base := strings.TrimSuffix(key, ext)
+		for i := 1; i <= maxAutoSuffix; i++ {
+			candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+			exists, err := ObjectExists(ctx, bucketName, candidate)
+			if err != nil {
+				return "", false, err
+			}
+			if !exists {
+				return candidate, false, nil
+			}
+		}
+		return "", false, ErrObjectExists
+	default:
+		return "", false, ErrObjectExists
+	}
+}
@@ -0,0 +1,81 @@
+package bucket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // empty if ErrInvalidKey is wanted
+	}{
+		{"Photo.JPEG", "photo.jpg"},
+		{"Café Tokyo.jpg", "cafe-tokyo.jpg"},
+		{"Ümlaut/ÅNGSTRÖM.HEIF", "umlaut/angstrom.heic"},
+		{"東京.jpg", "東京.jpg"},
+		{`2024\03\scan.TIF`, "2024/03/scan.tiff"},
+		{"a//b/./c.png", "a/b/c.png"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"hello   world!!", "hello-world"},
+		{".hidden", "hidden"},
+		{"", ""},
+		{"/../.", ""},
+		{"!!!.", ""},
+		{"trash/photo.jpg", ""},
+		{"Trash/photo.jpg", ""},
+		{strings.Repeat("a", maxKeyLength+1), ""},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeKey(tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("NormalizeKey(%q) = %q, %v; want ErrInvalidKey", tt.name, got, err)
+			}
+		} else if got != tt.want || err != nil {
+			t.Errorf("NormalizeKey(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyKeyTemplate(t *testing.T) {
+	date := time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tmpl, name, hash string
+		want             string // empty if ErrInvalidKey is wanted
+	}{
+		{"{name}{ext}", "IMG 1.JPG", "", "img-1.jpg"},
+		{"{yyyy}/{mm}/{dd}/{name}{ext}", "IMG 1.JPG", "", "2024/03/05/img-1.jpg"},
+		{"{hash}{ext}", "IMG 1.jpeg", "9f86d081", "9f86d081.jpg"},
+		{"{yyyy}/{hash}", "scan", "9f86d081", "2024/9f86d081"},
+		{"photos/{name}{ext}", "../../x.png", "", "photos/x.png"},
+		{"{hash}{ext}", "IMG 1.jpg", "", ""},
+		{"trash/{name}{ext}", "IMG 1.jpg", "", ""},
+		{"{name}", "", "", ""},
+	}
+	for _, tt := range tests {
+		got, err := ApplyKeyTemplate(tt.tmpl, tt.name, tt.hash, date)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("ApplyKeyTemplate(%q, %q, %q) = %q, %v; want ErrInvalidKey", tt.tmpl, tt.name, tt.hash, got, err)
+			}
+		} else if got != tt.want || err != nil {
+			t.Errorf("ApplyKeyTemplate(%q, %q, %q) = %q, %v; want %q", tt.tmpl, tt.name, tt.hash, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	for _, s := range []string{"reject", "auto-suffix", "overwrite-with-confirm"} {
+		if p, err := ParseCollisionPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseCollisionPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"", "overwrite", "Reject"} {
+		if _, err := ParseCollisionPolicy(s); err == nil {
+			t.Errorf("ParseCollisionPolicy(%q) succeeded, want an error", s)
+		}
+	}
+}
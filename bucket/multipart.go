@@ -0,0 +1,249 @@
+package bucket
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MultipartPartSize is the size of every part uploaded by PutLargeObjectInBucket (except the last).
+// R2 requires all non-final parts to be the same size and at least 5 MiB.
+const MultipartPartSize int64 = 8 << 20
+
+// MultipartThreshold is the object size above which uploads should go through multipart upload
+// instead of a single PutObject.
+const MultipartThreshold int64 = 64 << 20
+
+// MultipartConcurrency is the number of parts PutLargeObjectInBucket uploads at the same time.
+const MultipartConcurrency = 4
+
+type Part struct {
+	PartNumber int32  `json:"part_number"` // 1-indexed part number
+	ETag       string `json:"etag"`        // etag returned by the bucket for this part
+	Size       int64  `json:"size"`        // size of the part in bytes
+}
+
+type MultipartUpload struct {
+	Name     string `json:"name"`      // object key
+	UploadID string `json:"upload_id"` // upload ID used to resume the upload
+}
+
+func CreateMultipartUpload(ctx context.Context, bucketName, objectKey string, metadata map[string]string, headers ObjectHeaders) (string, error) {
+	output, err := S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		Metadata:           encodeMetadata(metadata),
+		ContentType:        optional(headers.ContentType),
+		CacheControl:       optional(headers.CacheControl),
+		ContentDisposition: optional(headers.ContentDisposition),
+	})
+	if err != nil {
+		return "", fmt.Errorf("create multipart upload %s: %w", objectKey, err)
+	}
+	return *output.UploadId, nil
+}
+
+// UploadPart uploads a single part of a multipart upload. size must be the exact number of bytes
+// that will be read from body.
+func UploadPart(ctx context.Context, bucketName, objectKey, uploadID string, partNumber int32, body io.Reader, size int64) (Part, error) {
+	output, err := S3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &bucketName,
+		Key:           &objectKey,
+		UploadId:      &uploadID,
+		PartNumber:    &partNumber,
+		Body:          body,
+		ContentLength: &size,
+	})
+	if err != nil {
+		return Part{}, fmt.Errorf("upload part %d of %s: %w", partNumber, objectKey, err)
+	}
+	return Part{
+		PartNumber: partNumber,
+		ETag:       aws.ToString(output.ETag),
+		Size:       size,
+	}, nil
+}
+
+// ListParts lists every part already uploaded for a multipart upload. clients use this to
+// resume an interrupted upload.
+func ListParts(ctx context.Context, bucketName, objectKey, uploadID string) ([]Part, error) {
+	var parts []Part
+	paginator := s3.NewListPartsPaginator(S3Client, &s3.ListPartsInput{
+		Bucket:   &bucketName,
+		Key:      &objectKey,
+		UploadId: &uploadID,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list parts of %s: %w", objectKey, err)
+		}
+		for _, p := range output.Parts {
+			parts = append(parts, Part{
+				PartNumber: aws.ToInt32(p.PartNumber),
+				ETag:       aws.ToString(p.ETag),
+				Size:       aws.ToInt64(p.Size),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// ListMultipartUploads lists the in-progress multipart uploads whose object key starts with prefix.
+func ListMultipartUploads(ctx context.Context, bucketName, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	paginator := s3.NewListMultipartUploadsPaginator(S3Client, &s3.ListMultipartUploadsInput{
+		Bucket: &bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list multipart uploads: %w", err)
+		}
+		for _, u := range output.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Name:     aws.ToString(u.Key),
+				UploadID: aws.ToString(u.UploadId),
+			})
+		}
+	}
+	return uploads, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object. unless overwrite is true,
+// it fails with ErrObjectExists if the object was created while the upload was in progress.
+func CompleteMultipartUpload(ctx context.Context, bucketName, objectKey, uploadID string, parts []Part, overwrite bool) error {
+	parts = slices.Clone(parts)
+	slices.SortFunc(parts, func(a, b Part) int { return int(a.PartNumber - b.PartNumber) })
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		}
+	}
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucketName,
+		Key:             &objectKey,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err := S3Client.CompleteMultipartUpload(ctx, input)
+	if isHTTPStatus(err, http.StatusPreconditionFailed) {
+		return ErrObjectExists
+	} else if err != nil {
+		return fmt.Errorf("complete multipart upload %s: %w", objectKey, err)
+	}
+	return nil
+}
+
+func AbortMultipartUpload(ctx context.Context, bucketName, objectKey, uploadID string) error {
+	_, err := S3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &bucketName,
+		Key:      &objectKey,
+		UploadId: &uploadID,
+	})
+	if err != nil {
+		return fmt.Errorf("abort multipart upload %s: %w", objectKey, err)
+	}
+	return nil
+}
+
+// ContentETag returns the ETag an object with body as its content gets when it's uploaded with
+// PutObjectInBucket (the MD5 of the content) or, above MultipartThreshold, PutLargeObjectInBucket
+// (the MD5 of the parts' MD5s, followed by the part count). objects uploaded with other part sizes
+// have different ETags.
+func ContentETag(body io.ReaderAt, size int64) (string, error) {
+	if size <= MultipartThreshold {
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(body, 0, size)); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	parts := md5.New()
+	partCount := (size + MultipartPartSize - 1) / MultipartPartSize
+	for i := range partCount {
+		offset := i * MultipartPartSize
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(body, offset, min(MultipartPartSize, size-offset))); err != nil {
+			return "", err
+		}
+		parts.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(parts.Sum(nil)), partCount), nil
+}
+
+// PutLargeObjectInBucket uploads body as a multipart upload, sending up to MultipartConcurrency
+// parts at once. progress (if not nil) is called once the upload is created, and then after each
+// part with the number of bytes uploaded so far. the upload is aborted if any part fails. see
+// CompleteMultipartUpload for overwrite.
+func PutLargeObjectInBucket(ctx context.Context, bucketName, objectKey string, metadata map[string]string, headers ObjectHeaders, body io.ReaderAt, size int64, overwrite bool, progress func(uploadID string, uploaded, total int64)) error {
+	uploadID, err := CreateMultipartUpload(ctx, bucketName, objectKey, metadata, headers)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(uploadID, 0, size)
+	}
+
+	partCount := int((size + MultipartPartSize - 1) / MultipartPartSize)
+	parts := make([]Part, partCount)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		uploaded atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, MultipartConcurrency)
+	for i := range partCount {
+		offset := int64(i) * MultipartPartSize
+		partSize := min(MultipartPartSize, size-offset)
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			part, err := UploadPart(ctx, bucketName, objectKey, uploadID, int32(i+1), io.NewSectionReader(body, offset, partSize), partSize)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err; cancel() })
+				return
+			}
+			parts[i] = part
+			if progress != nil {
+				progress(uploadID, uploaded.Add(partSize), size)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		// use a fresh context since ctx has been cancelled
+		if err := AbortMultipartUpload(context.Background(), bucketName, objectKey, uploadID); err != nil {
+			return fmt.Errorf("%w (abort also failed: %v)", firstErr, err)
+		}
+		return firstErr
+	}
+	if err := CompleteMultipartUpload(ctx, bucketName, objectKey, uploadID, parts, overwrite); err != nil {
+		AbortMultipartUpload(context.Background(), bucketName, objectKey, uploadID)
+		return err
+	}
+	return nil
+}
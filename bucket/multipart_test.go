@@ -0,0 +1,36 @@
+package bucket
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// zeros reads as zero bytes, so large bodies don't have to be allocated.
+type zeros struct{}
+
+func (zeros) ReadAt(p []byte, off int64) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestContentETag(t *testing.T) {
+	tests := []struct {
+		name string
+		body io.ReaderAt
+		size int64
+		want string
+	}{
+		{"empty", strings.NewReader(""), 0, "d41d8cd98f00b204e9800998ecf8427e"},
+		{"single upload", strings.NewReader("hello"), 5, "5d41402abc4b2a76b9719d911017c592"},
+		{"at the multipart threshold", zeros{}, MultipartThreshold, "7f614da9329cd3aebf59b91aadc30bf0"},
+		// 8 full parts and a 1 byte one
+		{"multipart", zeros{}, MultipartThreshold + 1, "2502284c8650f62cbf69b9d51e51891e-9"},
+	}
+	for _, tt := range tests {
+		got, err := ContentETag(tt.body, tt.size)
+		if got != tt.want || err != nil {
+			t.Errorf("%s: ContentETag = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
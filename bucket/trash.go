@@ -0,0 +1,83 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TrashPrefix is the prefix trashed objects are moved under. trashed objects keep their original
+// key after the prefix, so "2024/kyoto.jpg" is trashed to "trash/2024/kyoto.jpg".
+//
+// note: the bucket is public, so trashed objects are still reachable at their trash URL until purged.
+const TrashPrefix = "trash/"
+
+func IsTrashKey(objectKey string) bool {
+	return strings.HasPrefix(objectKey, TrashPrefix)
+}
+
+// TrashObject moves an object into the trash.
+func TrashObject(ctx context.Context, bucketName, objectKey string) error {
+	return MoveObject(ctx, bucketName, objectKey, TrashPrefix+objectKey)
+}
+
+// RestoreObject moves a trashed object back to its original key. it fails with ErrObjectExists if
+// something has been uploaded to that key since.
+func RestoreObject(ctx context.Context, bucketName, objectKey string) error {
+	exists, err := ObjectExists(ctx, bucketName, objectKey)
+	if err != nil {
+		return err
+	} else if exists {
+		return ErrObjectExists
+	}
+	return MoveObject(ctx, bucketName, TrashPrefix+objectKey, objectKey)
+}
+
+// ListTrashedObjects lists every object in the trash. names are the original keys (without
+// TrashPrefix) and LastModified is when the object was trashed.
+func ListTrashedObjects(ctx context.Context, bucketName string) ([]Object, error) {
+	var objects []Object
+	paginator := s3.NewListObjectsV2Paginator(S3Client, &s3.ListObjectsV2Input{
+		Bucket: &bucketName,
+		Prefix: aws.String(TrashPrefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list trashed objects: %w", err)
+		}
+		for _, obj := range output.Contents {
+			objects = append(objects, Object{
+				Name:         strings.TrimPrefix(*obj.Key, TrashPrefix),
+				Size:         aws.ToInt64(obj.Size),
+				PublicURL:    PublicURL(*obj.Key),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// PurgeTrash permanently deletes objects that were trashed before cutoff. it returns the number of
+// objects deleted.
+func PurgeTrash(ctx context.Context, bucketName string, cutoff time.Time) (int, error) {
+	objects, err := ListTrashedObjects(ctx, bucketName)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, obj := range objects {
+		if !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := DeleteObject(ctx, bucketName, TrashPrefix+obj.Name); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
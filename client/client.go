@@ -0,0 +1,239 @@
+// Package client is a Go client for the /api/v1 API. responses use the gen/db types the server
+// encodes them from.
+//
+//	c, err := client.New("https://ajitesh.cc")
+//	if err != nil { ... }
+//	if err := c.Login(ctx, totpCode); err != nil { ... }
+//	photos, err := c.ListPhotos(ctx)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the cookie the server keeps admin sessions in (see server/admin.go)
+const adminTokenCookie = "admin_token"
+
+// Client calls the API. admin sessions (see Login) are kept in HTTPClient's cookie jar.
+type Client struct {
+	BaseURL    *url.URL      // the server, e.g. https://ajitesh.cc (without /api/v1)
+	HTTPClient *http.Client  // must have a cookie jar for Login
+	MaxRetries int           // retries of requests that fail with 429s and 5xxs (see retryable)
+	RetryWait  time.Duration // wait before the first retry; it doubles every retry, unless the server sends Retry-After
+}
+
+// New returns a client for the server at baseURL, with a cookie jar, 3 retries and a 30 second
+// timeout.
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base URL: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base URL %q must be http or https", baseURL)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		BaseURL:    u,
+		HTTPClient: &http.Client{Jar: jar, Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		RetryWait:  500 * time.Millisecond,
+	}, nil
+}
+
+// Error is an error response (the server's APIError).
+type Error struct {
+	Status    int      `json:"-"`
+	Code      string   `json:"code"`                 // e.g. "not_found" or "already_exists"
+	Message   string   `json:"message"`              // human-readable
+	Field     string   `json:"field,omitempty"`      // the request field at fault, if any
+	RequestID string   `json:"request_id,omitempty"` // for finding the request in the server's logs
+	Errors    []*Error `json:"errors,omitempty"`     // every invalid field, when there's more than one
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// request is one API call. body is sent as is, so it can be resent by retries.
+type request struct {
+	method      string
+	path        string // under /api/v1, with path segments escaped (see escape)
+	query       url.Values
+	contentType string
+	body        []byte
+}
+
+// escape escapes a path segment (tag titles can have slashes).
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
+
+// json sends in as the JSON body (if it isn't nil) and decodes the response into out (if it isn't
+// nil).
+func (c *Client) json(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	req := request{method: method, path: path, query: query}
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		req.contentType, req.body = "application/json", body
+	}
+	return c.do(ctx, req, out)
+}
+
+// do sends req, retrying it as retryable allows, and decodes the response into out.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	u, err := url.Parse(c.BaseURL.String() + "/api/v1" + req.path)
+	if err != nil {
+		return fmt.Errorf("build URL: %w", err)
+	}
+	if len(req.query) > 0 {
+		u.RawQuery = req.query.Encode()
+	}
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), bytes.NewReader(req.body))
+		if err != nil {
+			return err
+		}
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		httpReq.Header.Set("Accept", "application/json")
+
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			return err
+		}
+		if attempt < c.MaxRetries && retryable(req.method, resp.StatusCode) {
+			delay := retryAfter(resp, wait)
+			io.Copy(io.Discard, resp.Body) // so the connection is reused
+			resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			wait *= 2
+			continue
+		}
+		defer resp.Body.Close()
+		return decodeResponse(resp, out)
+	}
+}
+
+// retryable reports whether a request that got status can be sent again. 429s and 502, 503 and
+// 504s (the request was turned away, or never reached a handler) are always retried; other 5xxs
+// only for methods that are safe to repeat.
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return status >= 500
+	}
+	return false
+}
+
+// retryAfter is how long to wait before retrying: the response's Retry-After (in seconds), or
+// fallback.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		apiErr := &Error{}
+		if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+			// not from the API (e.g. a proxy's error page)
+			apiErr = &Error{Code: "error", Message: strings.TrimSpace(string(body))}
+			if apiErr.Message == "" {
+				apiErr.Message = http.StatusText(resp.StatusCode)
+			}
+		}
+		apiErr.Status = resp.StatusCode
+		for _, e := range apiErr.Errors {
+			e.Status = resp.StatusCode
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// Login starts an admin session with a TOTP code. the session lasts an hour; see Token to keep it
+// across clients.
+func (c *Client) Login(ctx context.Context, totp string) error {
+	return c.json(ctx, http.MethodPost, "/admin", nil, map[string]string{"totp": totp}, nil)
+}
+
+// IsAdmin reports whether the client has a valid admin session.
+func (c *Client) IsAdmin(ctx context.Context) (bool, error) {
+	var resp struct {
+		IsAdmin bool `json:"is_admin"`
+	}
+	err := c.json(ctx, http.MethodGet, "/admin", nil, nil, &resp)
+	return resp.IsAdmin, err
+}
+
+// Token returns the admin session token set by Login, or "" if there isn't one.
+func (c *Client) Token() string {
+	if c.HTTPClient.Jar == nil {
+		return ""
+	}
+	u := *c.BaseURL
+	u.Path += "/api/v1/admin" // where the server sets the cookie
+	for _, cookie := range c.HTTPClient.Jar.Cookies(&u) {
+		if cookie.Name == adminTokenCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// SetToken resumes an admin session with a token from Token.
+func (c *Client) SetToken(token string) {
+	if c.HTTPClient.Jar == nil {
+		jar, _ := cookiejar.New(nil) // never fails without options
+		c.HTTPClient.Jar = jar
+	}
+	c.HTTPClient.Jar.SetCookies(c.BaseURL, []*http.Cookie{{
+		Name:   adminTokenCookie,
+		Value:  token,
+		Path:   "/",
+		Secure: c.BaseURL.Scheme == "https",
+	}})
+}
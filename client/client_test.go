@@ -0,0 +1,270 @@
+package client_test
+
+// these tests run the API in-process, so they need the server's environment (see env/env.go) and
+// are skipped without one. tests that need the database are skipped if POSTGRES_CONNECTION_URI
+// can't be reached; it must have schema.sql applied.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/tiredkangaroo/ajiteshcc/client"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+	"github.com/tiredkangaroo/ajiteshcc/server"
+)
+
+// newTestClient serves the API with httptest (over TLS, since the admin cookie is Secure outside
+// DEBUG) and returns a client for it.
+func newTestClient(t *testing.T) (*client.Client, *pgxpool.Pool) {
+	t.Helper()
+	if env.Err != nil {
+		t.Skipf("environment unavailable: %v", env.Err)
+	}
+	pool, err := pgxpool.New(context.Background(), env.DefaultEnv.POSTGRES_CONNECTION_URI) // connects lazily
+	if err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	srv := &server.Server{Conn: pool, Queries: db.New(pool)}
+	ts := httptest.NewTLSServer(srv.Router())
+	t.Cleanup(ts.Close)
+	return testClient(t, ts), pool
+}
+
+func testClient(t *testing.T, ts *httptest.Server) *client.Client {
+	t.Helper()
+	c, err := client.New(ts.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.HTTPClient.Transport = ts.Client().Transport
+	c.RetryWait = time.Millisecond
+	return c
+}
+
+func requireDB(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := pool.Ping(ctx); err != nil {
+		t.Skipf("database unavailable: %v", err)
+	}
+}
+
+// login logs c in with a TOTP code generated from TOTP_SECRET.
+func login(t *testing.T, c *client.Client) {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(env.DefaultEnv.TOTP_SECRET, time.Now(), totp.ValidateOpts{
+		Period:    30,
+		Digits:    otp.DigitsEight,
+		Algorithm: otp.AlgorithmSHA256,
+	})
+	if err != nil {
+		t.Skipf("generate TOTP code: %v", err)
+	}
+	if err := c.Login(context.Background(), code); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+}
+
+// apiError returns err as a *client.Error, failing the test if it isn't one.
+func apiError(t *testing.T, err error) *client.Error {
+	t.Helper()
+	var e *client.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want a *client.Error", err)
+	}
+	return e
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	if ok, err := c.IsAdmin(ctx); err != nil || ok {
+		t.Fatalf("IsAdmin before login = %v, %v; want false, nil", ok, err)
+	}
+	if e := apiError(t, c.Login(ctx, "00000000")); e.Status != 403 || e.Code != "forbidden" {
+		t.Fatalf("login with a bad code: got %v, want 403 forbidden", e)
+	}
+	login(t, c)
+	if ok, err := c.IsAdmin(ctx); err != nil || !ok {
+		t.Fatalf("IsAdmin after login = %v, %v; want true, nil", ok, err)
+	}
+
+	// the session can be moved to another client
+	token := c.Token()
+	if token == "" {
+		t.Fatal("no token after login")
+	}
+	other, err := client.New(c.BaseURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.HTTPClient.Transport = c.HTTPClient.Transport
+	other.SetToken(token)
+	if ok, err := other.IsAdmin(ctx); err != nil || !ok {
+		t.Fatalf("IsAdmin with token = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	e := apiError(t, c.AddTag(ctx, client.AddTagRequest{Title: "x"}))
+	if e.Status != 403 || e.Code != "forbidden" {
+		t.Fatalf("AddTag without login: got %v, want 403 forbidden", e)
+	}
+	if e.RequestID == "" {
+		t.Error("error has no request ID")
+	}
+
+	login(t, c)
+	e = apiError(t, c.AddTag(ctx, client.AddTagRequest{}))
+	if e.Status != 400 || e.Code != "missing_field" || e.Field != "title" {
+		t.Fatalf("AddTag without title: got %v (field %q), want 400 missing_field (field title)", e, e.Field)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	var statuses []int // responses before a 200
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statuses[n-1])
+			json.NewEncoder(w).Encode(map[string]string{"code": "error", "message": "try again"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"is_admin": true})
+	}))
+	defer ts.Close()
+	c := testClient(t, ts)
+
+	tests := []struct {
+		name      string
+		statuses  []int
+		call      func() error
+		wantCalls int32
+		wantErr   int // status of the error, 0 for none
+	}{
+		{"429 and 503 are retried", []int{429, 503}, func() error { _, err := c.IsAdmin(ctx); return err }, 3, 0},
+		{"500 is retried for GET", []int{500}, func() error { _, err := c.IsAdmin(ctx); return err }, 2, 0},
+		{"500 isn't retried for POST", []int{500}, func() error { return c.Login(ctx, "x") }, 1, 500},
+		{"retries run out", []int{429, 429, 429, 429, 429}, func() error { _, err := c.IsAdmin(ctx); return err }, 4, 429},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			statuses = tt.statuses
+			err := tt.call()
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr == 0 && err != nil {
+				t.Errorf("got error %v, want none", err)
+			} else if tt.wantErr != 0 && apiError(t, err).Status != tt.wantErr {
+				t.Errorf("got error %v, want status %d", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTagsAndPosts(t *testing.T) {
+	ctx := context.Background()
+	c, pool := newTestClient(t)
+	requireDB(t, pool)
+	login(t, c)
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	parent := "client/test " + suffix // the slash has to survive the path
+	child := "client test child " + suffix
+	slug := "client-test-" + suffix
+	if err := c.AddTag(ctx, client.AddTagRequest{Title: parent}); err != nil {
+		t.Fatalf("add parent tag: %v", err)
+	}
+	t.Cleanup(func() { c.DeleteTag(ctx, parent) })
+	if err := c.AddTag(ctx, client.AddTagRequest{Title: child, Parent: parent}); err != nil {
+		t.Fatalf("add child tag: %v", err)
+	}
+	t.Cleanup(func() { c.DeleteTag(ctx, child) })
+	if e := apiError(t, c.AddTag(ctx, client.AddTagRequest{Title: child})); e.Status != 409 {
+		t.Errorf("adding a tag twice: got %v, want a 409", e)
+	}
+
+	if err := c.AddPost(ctx, client.AddPostRequest{Slug: slug, Content: "hello", Tags: []string{child}}); err != nil {
+		t.Fatalf("add post: %v", err)
+	}
+	t.Cleanup(func() { c.DeletePost(ctx, slug) })
+	post, err := c.GetPost(ctx, slug)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	if post.Published || post.Content != "hello" {
+		t.Errorf("got post %+v, want an unpublished post with content hello", post)
+	}
+	if titles := tagTitles(t, post.Tags); !slices.Equal(titles, []string{child}) {
+		t.Errorf("post has tags %q, want %q", titles, child)
+	}
+
+	// the post is found through the parent tag
+	posts, err := c.TagPosts(ctx, parent)
+	if err != nil {
+		t.Fatalf("tag posts: %v", err)
+	}
+	if !slices.ContainsFunc(posts, func(p db.GetPostsByTagTreeRow) bool { return p.Slug == slug }) {
+		t.Errorf("posts of %q don't include %s", parent, slug)
+	}
+	page, err := c.GetTag(ctx, parent, 1, 10)
+	if err != nil {
+		t.Fatalf("get tag: %v", err)
+	}
+	if page.Tag.Title != parent || page.PostCount != 0 { // unpublished posts aren't counted
+		t.Errorf("got tag %q with %d posts, want %q with 0", page.Tag.Title, page.PostCount, parent)
+	}
+
+	resp, err := c.BulkTag(ctx, client.BulkTagRequest{PostSlugs: []string{slug}, Add: []string{parent, child}})
+	if err != nil {
+		t.Fatalf("bulk tag: %v", err)
+	}
+	if r := resp.Results[0]; !slices.Equal(r.Added, []string{parent}) || !slices.Equal(r.Unchanged, []string{child}) {
+		t.Errorf("bulk tag: got added %q and unchanged %q, want %q and %q", r.Added, r.Unchanged, parent, child)
+	}
+
+	if err := c.DeletePost(ctx, slug); err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	if _, err := c.GetPost(ctx, slug); !client.IsNotFound(err) {
+		t.Errorf("get deleted post: got %v, want a 404", err)
+	}
+}
+
+func tagTitles(t *testing.T, tags json.RawMessage) []string {
+	t.Helper()
+	var decoded []struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(tags, &decoded); err != nil {
+		t.Fatalf("decode tags: %v", err)
+	}
+	titles := make([]string, len(decoded))
+	for i, tag := range decoded {
+		titles[i] = tag.Title
+	}
+	return titles
+}
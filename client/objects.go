@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// Object is an object in the photos bucket.
+type Object struct {
+	Name         string            `json:"name"` // object key
+	Size         int64             `json:"size"` // size in bytes
+	PublicURL    string            `json:"public_url"`
+	Metadata     map[string]string `json:"metadata"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+// ListObjects returns every object in the photos bucket, except trashed ones (admin only).
+func (c *Client) ListObjects(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	err := c.json(ctx, http.MethodGet, "/objects", nil, nil, &objects)
+	return objects, err
+}
+
+type UploadObjectRequest struct {
+	File      io.Reader // the photo (read into memory, so the upload can be retried)
+	FileName  string    // the file's name; the object key defaults to it
+	Sidecar   io.Reader // optional XMP sidecar, overriding the photo's embedded metadata
+	Name      string    // object key (normalized and run through R2_PHOTOS_KEY_TEMPLATE)
+	Collision string    // collision policy ("reject", "auto-suffix" or "overwrite-with-confirm"), overrides the server's
+	Overwrite bool      // confirms overwriting an existing object (overwrite-with-confirm only)
+}
+
+// UploadObject uploads a photo to the bucket and returns its object key, which may differ from the
+// requested one (admin only).
+func (c *Client) UploadObject(ctx context.Context, req UploadObjectRequest) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{"name": req.Name, "collision": req.Collision}
+	if req.Overwrite {
+		fields["overwrite"] = "true"
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	files := []struct {
+		field, name string
+		r           io.Reader
+	}{{"file", req.FileName, req.File}, {"sidecar", req.FileName + ".xmp", req.Sidecar}}
+	for _, f := range files {
+		if f.r == nil {
+			continue
+		}
+		w, err := form.CreateFormFile(f.field, f.name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(w, f.r); err != nil {
+			return "", fmt.Errorf("read %s: %w", f.field, err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Name string `json:"name"`
+	}
+	err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/objects",
+		contentType: form.FormDataContentType(),
+		body:        body.Bytes(),
+	}, &resp)
+	return resp.Name, err
+}
+
+// UpdateObjectMetadata replaces an object's metadata, and that of every photo using it (admin
+// only).
+func (c *Client) UpdateObjectMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	return c.json(ctx, http.MethodPatch, "/objects/"+escape(name), nil, map[string]any{"metadata": metadata}, nil)
+}
+
+// DeleteObject moves an object no photo uses to the trash (admin only).
+func (c *Client) DeleteObject(ctx context.Context, name string) error {
+	return c.json(ctx, http.MethodDelete, "/objects/"+escape(name), nil, nil, nil)
+}
+
+type MoveObjectRequest struct {
+	NewName   string `json:"new_name"`            // new object key (normalized)
+	Collision string `json:"collision,omitempty"` // collision policy, overrides the server's
+	Overwrite bool   `json:"overwrite,omitempty"` // confirms overwriting an existing object (overwrite-with-confirm only)
+}
+
+type MoveObjectResponse struct {
+	Name          string `json:"name"` // the object's new key
+	PhotosUpdated int64  `json:"photos_updated"`
+	Warning       string `json:"warning,omitempty"` // set if the original couldn't be deleted
+}
+
+// MoveObject moves (or renames) an object and points every photo using it at the new key (admin
+// only).
+func (c *Client) MoveObject(ctx context.Context, name string, req MoveObjectRequest) (*MoveObjectResponse, error) {
+	resp := &MoveObjectResponse{}
+	if err := c.json(ctx, http.MethodPost, "/objects/"+escape(name)+"/move", nil, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
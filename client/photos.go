@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// ListPhotos returns every photo with its tags.
+func (c *Client) ListPhotos(ctx context.Context) ([]db.GetAllPhotosWithTagsRow, error) {
+	var photos []db.GetAllPhotosWithTagsRow
+	err := c.json(ctx, http.MethodGet, "/photos", nil, nil, &photos)
+	return photos, err
+}
+
+// GetPhoto returns a photo with its tags.
+func (c *Client) GetPhoto(ctx context.Context, id int32) (db.GetPhotoByIDWithTagsRow, error) {
+	var photo db.GetPhotoByIDWithTagsRow
+	err := c.json(ctx, http.MethodGet, "/photos/"+photoPath(id), nil, nil, &photo)
+	return photo, err
+}
+
+type AddPhotoRequest struct {
+	PhotoURL string   `json:"photo_url"`         // public URL of the photo's object (see UploadObject)
+	Title    string   `json:"title,omitempty"`   // defaults to the title in the photo's metadata
+	Comment  string   `json:"comment,omitempty"` // defaults to the caption in the photo's metadata
+	Tags     []string `json:"tags,omitempty"`    // slugs or titles; the photo's keywords are added too
+}
+
+type AddedPhoto struct {
+	ID          int32    `json:"id"`
+	SkippedTags []string `json:"skipped_tags"` // tags that don't exist, and tags or keywords in the trash; the photo was added without them
+}
+
+// AddPhoto adds a photo of an object in the bucket (admin only).
+func (c *Client) AddPhoto(ctx context.Context, req AddPhotoRequest) (AddedPhoto, error) {
+	var added AddedPhoto
+	err := c.json(ctx, http.MethodPost, "/photos", nil, req, &added)
+	return added, err
+}
+
+// DeletePhoto moves a photo to the trash (admin only).
+func (c *Client) DeletePhoto(ctx context.Context, id int32) error {
+	return c.json(ctx, http.MethodDelete, "/photos/"+photoPath(id), nil, nil, nil)
+}
+
+// UpdatePhotoMetadata replaces a photo's metadata, on the photo and its object (admin only). it
+// returns the metadata as stored.
+func (c *Client) UpdatePhotoMetadata(ctx context.Context, id int32, metadata map[string]string) (map[string]string, error) {
+	var md map[string]string
+	err := c.json(ctx, http.MethodPatch, "/photos/"+photoPath(id)+"/metadata", nil, map[string]any{"metadata": metadata}, &md)
+	return md, err
+}
+
+// ExtractPhotoMetadata re-extracts a photo's metadata from its original (admin only).
+func (c *Client) ExtractPhotoMetadata(ctx context.Context, id int32) (map[string]string, error) {
+	var md map[string]string
+	err := c.json(ctx, http.MethodPost, "/photos/"+photoPath(id)+"/metadata/extract", nil, nil, &md)
+	return md, err
+}
+
+// ExtractPhotoMetadataAsync re-extracts a photo's metadata in a background job and returns the job's
+// ID (admin only).
+func (c *Client) ExtractPhotoMetadataAsync(ctx context.Context, id int32) (int64, error) {
+	var resp struct {
+		JobID int64 `json:"job_id"`
+	}
+	err := c.json(ctx, http.MethodPost, "/photos/"+photoPath(id)+"/metadata/extract", url.Values{"async": {"true"}}, nil, &resp)
+	return resp.JobID, err
+}
+
+// TagPhoto adds a tag (by slug or title) to a photo (admin only). tagging a photo with a tag it
+// already has isn't an error.
+func (c *Client) TagPhoto(ctx context.Context, id int32, tag string) error {
+	return c.json(ctx, http.MethodPatch, "/photos/"+photoPath(id)+"/tag/"+escape(tag), nil, nil, nil)
+}
+
+// UntagPhoto removes a tag (by slug or title) from a photo (admin only).
+func (c *Client) UntagPhoto(ctx context.Context, id int32, tag string) error {
+	return c.json(ctx, http.MethodDelete, "/photos/"+photoPath(id)+"/tag/"+escape(tag), nil, nil, nil)
+}
+
+func photoPath(id int32) string {
+	return strconv.FormatInt(int64(id), 10)
+}
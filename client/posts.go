@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// ListPosts returns every post with its tags. only admins get unpublished posts.
+func (c *Client) ListPosts(ctx context.Context) ([]db.ListPostsWithTagsRow, error) {
+	var posts []db.ListPostsWithTagsRow
+	err := c.json(ctx, http.MethodGet, "/posts", nil, nil, &posts)
+	return posts, err
+}
+
+// GetPost returns a post with its tags. unpublished posts are only found by admins.
+func (c *Client) GetPost(ctx context.Context, slug string) (db.GetPostBySlugWithTagsRow, error) {
+	var post db.GetPostBySlugWithTagsRow
+	err := c.json(ctx, http.MethodGet, "/posts/"+escape(slug), nil, nil, &post)
+	return post, err
+}
+
+type AddPostRequest struct {
+	Slug      string   `json:"slug"`
+	Published bool     `json:"published"`
+	Content   string   `json:"content"`
+	Tags      []string `json:"tags,omitempty"` // slugs or titles
+}
+
+// AddPost adds a post (admin only).
+func (c *Client) AddPost(ctx context.Context, req AddPostRequest) error {
+	return c.json(ctx, http.MethodPost, "/posts", nil, req, nil)
+}
+
+// UpdatePostRequest changes the fields that are set.
+type UpdatePostRequest struct {
+	Content   *string `json:"content,omitempty"`
+	Published *bool   `json:"published,omitempty"`
+}
+
+// UpdatePost edits a post's content and/or (un)publishes it (admin only).
+func (c *Client) UpdatePost(ctx context.Context, slug string, req UpdatePostRequest) (db.UpdatePostRow, error) {
+	var post db.UpdatePostRow
+	err := c.json(ctx, http.MethodPatch, "/posts/"+escape(slug), nil, req, &post)
+	return post, err
+}
+
+// DeletePost moves a post to the trash (admin only).
+func (c *Client) DeletePost(ctx context.Context, slug string) error {
+	return c.json(ctx, http.MethodDelete, "/posts/"+escape(slug), nil, nil, nil)
+}
+
+// TagPost adds a tag (by slug or title) to a post (admin only).
+func (c *Client) TagPost(ctx context.Context, slug, tag string) error {
+	return c.json(ctx, http.MethodPatch, "/posts/"+escape(slug)+"/tag/"+escape(tag), nil, nil, nil)
+}
+
+// UntagPost removes a tag (by slug or title) from a post (admin only).
+func (c *Client) UntagPost(ctx context.Context, slug, tag string) error {
+	return c.json(ctx, http.MethodDelete, "/posts/"+escape(slug)+"/tag/"+escape(tag), nil, nil, nil)
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// tags are named by slug or title everywhere (see server.ResolveTagMiddleware).
+
+// ListTags returns every tag.
+func (c *Client) ListTags(ctx context.Context) ([]db.ListTagsRow, error) {
+	var tags []db.ListTagsRow
+	err := c.json(ctx, http.MethodGet, "/tags", nil, nil, &tags)
+	return tags, err
+}
+
+type TagWithCounts struct {
+	Title      string      `json:"title"`
+	Comment    pgtype.Text `json:"comment"`
+	Slug       string      `json:"slug"`
+	PhotoCount int64       `json:"photo_count"`
+	PostCount  int64       `json:"post_count"` // published posts only
+}
+
+// ListTagsWithCounts returns every tag with the number of photos and published posts tagged with
+// it.
+func (c *Client) ListTagsWithCounts(ctx context.Context) ([]TagWithCounts, error) {
+	var tags []TagWithCounts
+	err := c.json(ctx, http.MethodGet, "/tags", url.Values{"with_counts": {"true"}}, nil, &tags)
+	return tags, err
+}
+
+type TagNode struct {
+	Title    string      `json:"title"`
+	Comment  pgtype.Text `json:"comment"`
+	Slug     string      `json:"slug"`
+	Children []*TagNode  `json:"children"`
+}
+
+// TagTree returns every tag nested under its parent.
+func (c *Client) TagTree(ctx context.Context) ([]*TagNode, error) {
+	var tags []*TagNode
+	err := c.json(ctx, http.MethodGet, "/tags", url.Values{"tree": {"true"}}, nil, &tags)
+	return tags, err
+}
+
+type TagPage struct {
+	Tag        db.GetTagRow                          `json:"tag"`
+	PhotoCount int64                                 `json:"photo_count"`
+	PostCount  int64                                 `json:"post_count"` // published posts only
+	Photos     []db.ListPhotosWithTagPageRow         `json:"photos"`
+	Posts      []db.ListPublishedPostsWithTagPageRow `json:"posts"`
+	Page       int32                                 `json:"page"`
+	PerPage    int32                                 `json:"per_page"`
+}
+
+// GetTag returns a tag with a page of its photos and published posts. page starts at 1; zero page
+// and perPage use the server's defaults.
+func (c *Client) GetTag(ctx context.Context, tag string, page, perPage int32) (*TagPage, error) {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(int(page)))
+	}
+	if perPage > 0 {
+		query.Set("per_page", strconv.Itoa(int(perPage)))
+	}
+	resp := &TagPage{}
+	if err := c.json(ctx, http.MethodGet, "/tags/"+escape(tag), query, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// TagPhotos returns the photos tagged with a tag or any of its descendants.
+func (c *Client) TagPhotos(ctx context.Context, tag string) ([]db.GetPhotosByTagTreeRow, error) {
+	var photos []db.GetPhotosByTagTreeRow
+	err := c.json(ctx, http.MethodGet, "/tags/"+escape(tag)+"/photos", nil, nil, &photos)
+	return photos, err
+}
+
+// TagPosts returns the posts tagged with a tag or any of its descendants. only admins get
+// unpublished posts.
+func (c *Client) TagPosts(ctx context.Context, tag string) ([]db.GetPostsByTagTreeRow, error) {
+	var posts []db.GetPostsByTagTreeRow
+	err := c.json(ctx, http.MethodGet, "/tags/"+escape(tag)+"/posts", nil, nil, &posts)
+	return posts, err
+}
+
+type AddTagRequest struct {
+	Title   string `json:"title"`
+	Comment string `json:"comment,omitempty"`
+	Parent  string `json:"parent,omitempty"` // slug or title of the parent tag
+}
+
+// AddTag adds a tag (admin only). it fails with a 409 if a tag with the same title is in the trash;
+// restore that one (POST /admin/trash/tags/:title/restore) instead.
+func (c *Client) AddTag(ctx context.Context, req AddTagRequest) error {
+	return c.json(ctx, http.MethodPost, "/tags", nil, req, nil)
+}
+
+// UpdateTagRequest changes the fields that are set.
+type UpdateTagRequest struct {
+	Title   string  `json:"title,omitempty"`   // new title
+	Comment *string `json:"comment,omitempty"` // new comment ("" removes it)
+	Parent  *string `json:"parent,omitempty"`  // slug or title of the new parent ("" makes the tag a root)
+	Slug    string  `json:"slug,omitempty"`    // new slug (regenerated from the new title if empty)
+}
+
+// UpdateTag renames a tag, changes its slug, comment or parent (admin only).
+func (c *Client) UpdateTag(ctx context.Context, tag string, req UpdateTagRequest) (db.UpdateTagRow, error) {
+	var updated db.UpdateTagRow
+	err := c.json(ctx, http.MethodPatch, "/tags/"+escape(tag), nil, req, &updated)
+	return updated, err
+}
+
+// DeleteTag moves a tag to the trash (admin only).
+func (c *Client) DeleteTag(ctx context.Context, tag string) error {
+	return c.json(ctx, http.MethodDelete, "/tags/"+escape(tag), nil, nil, nil)
+}
+
+// MergeTag moves every photo and post tagged with tag to into and deletes tag (admin only).
+func (c *Client) MergeTag(ctx context.Context, tag, into string) error {
+	return c.json(ctx, http.MethodPost, "/tags/"+escape(tag)+"/merge", nil, map[string]string{"into": into}, nil)
+}
+
+// SuggestTags returns tags matching what's been typed so far, most used first (admin only). zero
+// limit uses the server's default.
+func (c *Client) SuggestTags(ctx context.Context, query string, limit int32) ([]db.SuggestTagsRow, error) {
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(int(limit)))
+	}
+	var tags []db.SuggestTagsRow
+	err := c.json(ctx, http.MethodGet, "/tags/suggest", params, nil, &tags)
+	return tags, err
+}
+
+type TagSuggestion struct {
+	Title   string      `json:"title"`
+	Comment pgtype.Text `json:"comment"`
+	Slug    string      `json:"slug"`            // empty for new tags
+	New     bool        `json:"new"`             // suggested from the photo's keywords; the tag doesn't exist yet
+	Source  string      `json:"source"`          // "keywords" or "similar_photos"
+	Score   float64     `json:"score,omitempty"` // similar_photos only; higher is better
+}
+
+// SuggestTagsForPhoto proposes tags for a photo: its keywords, then the tags of similar photos
+// (admin only).
+func (c *Client) SuggestTagsForPhoto(ctx context.Context, id int32, limit int32) ([]TagSuggestion, error) {
+	params := url.Values{"photo_id": {photoPath(id)}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(int(limit)))
+	}
+	var tags []TagSuggestion
+	err := c.json(ctx, http.MethodGet, "/tags/suggest", params, nil, &tags)
+	return tags, err
+}
+
+type BulkTagRequest struct {
+	PhotoIDs      []int32  `json:"photo_ids,omitempty"`
+	PostSlugs     []string `json:"post_slugs,omitempty"`
+	Add           []string `json:"add,omitempty"`            // slugs or titles of tags to add
+	Remove        []string `json:"remove,omitempty"`         // slugs or titles of tags to remove
+	CreateMissing bool     `json:"create_missing,omitempty"` // create tags in Add that don't exist (trashed tags are never recreated)
+}
+
+type BulkTagResponse struct {
+	CreatedTags []string        `json:"created_tags"`
+	Results     []BulkTagResult `json:"results"`
+}
+
+type BulkTagResult struct {
+	PhotoID   int32    `json:"photo_id,omitempty"`
+	PostSlug  string   `json:"post_slug,omitempty"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Unchanged []string `json:"unchanged"`       // tags it already had (add) or didn't have (remove)
+	Error     string   `json:"error,omitempty"` // e.g. "photo not found"; nothing was changed for the item
+}
+
+// BulkTag adds and removes tags on many photos and posts in one transaction (admin only). it's
+// safe to retry.
+func (c *Client) BulkTag(ctx context.Context, req BulkTagRequest) (*BulkTagResponse, error) {
+	resp := &BulkTagResponse{}
+	if err := c.json(ctx, http.MethodPost, "/tags/bulk", nil, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,194 @@
+// ajctl manages photos, posts, tags and objects through the API, e.g.
+//
+//	ajctl login
+//	ajctl photos upload -add -tags iceland,2024 ~/exports/DSC_0001.jpg
+//	ajctl posts edit hello-world
+//	ajctl -json tags list
+//
+// the session started by login is kept in the user's config directory (see session.go), so later
+// commands are run as admin until it expires.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tiredkangaroo/ajiteshcc/client"
+)
+
+const usage = `usage: ajctl [-server URL] [-json] [-photos-url URL] <command> [flags] [args]
+
+commands:
+  login [-code CODE]                          start an admin session (prompts for the TOTP code)
+  logout                                      forget the admin session
+
+  photos list
+  photos upload [-name KEY] [-sidecar XMP] [-add] [-title T] [-comment C] [-tags a,b] FILE
+                                              upload a photo to the bucket, and with -add, add it
+  photos add [-title T] [-comment C] [-tags a,b] URL
+  photos tag [-remove] ID TAG...
+
+  posts list
+  posts new [-file F] [-publish] [-tags a,b] SLUG
+                                              write the post in $EDITOR unless -file is given
+  posts publish [-unpublish] SLUG
+  posts edit SLUG                             edit the post in $EDITOR
+
+  tags list [-tree] [-counts]
+  tags merge TAG INTO
+
+  objects ls
+
+flags go before arguments. tags are named by slug or title.
+`
+
+// app is what every command gets: the client and how to print results.
+type app struct {
+	client   *client.Client
+	session  *session
+	jsonOut  bool
+	photoURL string // public URL of the photos bucket, for photos upload -add
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := flag.String("server", os.Getenv("AJCTL_SERVER"), "API server (default: the logged in server, or https://ajitesh.cc); also AJCTL_SERVER")
+	jsonOut := flag.Bool("json", false, "print JSON instead of tables")
+	photoURL := flag.String("photos-url", os.Getenv("AJCTL_PHOTOS_URL"), "public URL of the photos bucket (e.g. https://photos.ajitesh.cc); also AJCTL_PHOTOS_URL")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, *server, *jsonOut, *photoURL, flag.Args()); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, server string, jsonOut bool, photoURL string, args []string) error {
+	sess, err := loadSession()
+	if err != nil {
+		return err
+	}
+	if server == "" {
+		server = sess.Server
+	}
+	if server == "" {
+		server = "https://ajitesh.cc"
+	}
+	c, err := client.New(server)
+	if err != nil {
+		return err
+	}
+	if sess.Server == c.BaseURL.String() && sess.Token != "" {
+		c.SetToken(sess.Token)
+	}
+	a := &app{client: c, session: sess, jsonOut: jsonOut, photoURL: photoURL}
+
+	type command func(ctx context.Context, args []string) error
+	commands := map[string]command{
+		"login":  a.login,
+		"logout": a.logout,
+	}
+	groups := map[string]map[string]command{
+		"photos":  {"list": a.listPhotos, "upload": a.uploadPhoto, "add": a.addPhoto, "tag": a.tagPhoto},
+		"posts":   {"list": a.listPosts, "new": a.newPost, "publish": a.publishPost, "edit": a.editPost},
+		"tags":    {"list": a.listTags, "merge": a.mergeTags},
+		"objects": {"ls": a.listObjects},
+	}
+	if cmd, ok := commands[args[0]]; ok {
+		return cmd(ctx, args[1:])
+	}
+	group, ok := groups[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q (see ajctl -h)", args[0])
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("missing %s subcommand (see ajctl -h)", args[0])
+	}
+	cmd, ok := group[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown command %q (see ajctl -h)", args[0]+" "+args[1])
+	}
+	return cmd(ctx, args[2:])
+}
+
+// flags returns a flag set for a command. parse errors exit like the top-level flags do.
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage of ajctl %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// print writes v as JSON with -json, and as a table (rows under header) otherwise.
+func (a *app) print(v any, header string, rows func(w io.Writer)) error {
+	if a.jsonOut {
+		return a.printResult(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	return w.Flush()
+}
+
+// printResult writes the result of a change as JSON with -json. otherwise the change was already
+// reported by done.
+func (a *app) printResult(v any) error {
+	if !a.jsonOut {
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// done reports a change with no result, unless output is JSON.
+func (a *app) done(format string, args ...any) {
+	if !a.jsonOut {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+func printError(err error) {
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		fmt.Fprintln(os.Stderr, "ajctl:", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ajctl: %s (%s)\n", apiErr.Message, apiErr.Code)
+	for _, e := range apiErr.Errors {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", e.Field, e.Message)
+	}
+	if apiErr.Status == 403 && apiErr.Code == "forbidden" {
+		fmt.Fprintln(os.Stderr, "  (run ajctl login)")
+	}
+	if apiErr.RequestID != "" {
+		fmt.Fprintln(os.Stderr, "  request ID:", apiErr.RequestID)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ajctl objects ls
+func (a *app) listObjects(ctx context.Context, args []string) error {
+	flags("objects ls").Parse(args)
+	objects, err := a.client.ListObjects(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(objects, "NAME\tSIZE\tMODIFIED\tURL", func(w io.Writer) {
+		for _, o := range objects {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Name, byteSize(o.Size), o.LastModified.Local().Format(time.DateTime), o.PublicURL)
+		}
+	})
+}
+
+// byteSize formats n bytes for people, e.g. 12.3 MB.
+func byteSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
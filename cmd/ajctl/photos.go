@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tiredkangaroo/ajiteshcc/client"
+)
+
+// ajctl photos list
+func (a *app) listPhotos(ctx context.Context, args []string) error {
+	flags("photos list").Parse(args)
+	photos, err := a.client.ListPhotos(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(photos, "ID\tTITLE\tURL\tTAGS", func(w io.Writer) {
+		for _, p := range photos {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.ID, p.Title.String, p.PhotoUrl, tagList(p.Tags))
+		}
+	})
+}
+
+// ajctl photos upload [-name KEY] [-sidecar XMP] [-add ...] FILE
+func (a *app) uploadPhoto(ctx context.Context, args []string) error {
+	fs := flags("photos upload")
+	name := fs.String("name", "", "object key (defaults to the file name)")
+	sidecar := fs.String("sidecar", "", "XMP sidecar overriding the photo's metadata")
+	collision := fs.String("collision", "", "collision policy: reject, auto-suffix or overwrite-with-confirm (defaults to the server's)")
+	overwrite := fs.Bool("overwrite", false, "confirm overwriting an existing object")
+	add := fs.Bool("add", false, "add a photo of the uploaded object (needs -photos-url)")
+	title := fs.String("title", "", "with -add: photo title (defaults to the title in its metadata)")
+	comment := fs.String("comment", "", "with -add: photo comment (defaults to the caption in its metadata)")
+	tags := fs.String("tags", "", "with -add: comma separated tags")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ajctl photos upload [flags] FILE")
+	}
+	if *add && a.photoURL == "" {
+		return fmt.Errorf("-add needs the photos bucket's public URL (-photos-url or AJCTL_PHOTOS_URL)")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	req := client.UploadObjectRequest{
+		File:      file,
+		FileName:  filepath.Base(fs.Arg(0)),
+		Name:      *name,
+		Collision: *collision,
+		Overwrite: *overwrite,
+	}
+	if *sidecar != "" {
+		f, err := os.Open(*sidecar)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		req.Sidecar = f
+	}
+	key, err := a.client.UploadObject(ctx, req)
+	if err != nil {
+		return err
+	}
+	a.done("uploaded %s", key)
+	result := map[string]string{"name": key}
+	if !*add {
+		return a.printResult(result)
+	}
+
+	photoURL, err := url.JoinPath(a.photoURL, strings.Split(key, "/")...)
+	if err != nil {
+		return err
+	}
+	added, err := a.client.AddPhoto(ctx, client.AddPhotoRequest{
+		PhotoURL: photoURL,
+		Title:    *title,
+		Comment:  *comment,
+		Tags:     splitList(*tags),
+	})
+	if err != nil {
+		return fmt.Errorf("uploaded %s, but unable to add the photo: %w", key, err)
+	}
+	a.doneAdding(photoURL, added)
+	result["photo_url"] = photoURL
+	result["photo_id"] = strconv.Itoa(int(added.ID))
+	return a.printResult(result)
+}
+
+// ajctl photos add [-title T] [-comment C] [-tags a,b] URL
+func (a *app) addPhoto(ctx context.Context, args []string) error {
+	fs := flags("photos add")
+	title := fs.String("title", "", "photo title (defaults to the title in its metadata)")
+	comment := fs.String("comment", "", "photo comment (defaults to the caption in its metadata)")
+	tags := fs.String("tags", "", "comma separated tags")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ajctl photos add [flags] URL")
+	}
+	added, err := a.client.AddPhoto(ctx, client.AddPhotoRequest{
+		PhotoURL: fs.Arg(0),
+		Title:    *title,
+		Comment:  *comment,
+		Tags:     splitList(*tags),
+	})
+	if err != nil {
+		return err
+	}
+	a.doneAdding(fs.Arg(0), added)
+	return a.printResult(added)
+}
+
+// doneAdding reports an added photo and the tags it was added without.
+func (a *app) doneAdding(photoURL string, added client.AddedPhoto) {
+	a.done("added photo %d (%s)", added.ID, photoURL)
+	if len(added.SkippedTags) > 0 {
+		a.done("skipped tags that don't exist: %s", strings.Join(added.SkippedTags, ", "))
+	}
+}
+
+// ajctl photos tag [-remove] ID TAG...
+func (a *app) tagPhoto(ctx context.Context, args []string) error {
+	fs := flags("photos tag")
+	remove := fs.Bool("remove", false, "remove the tags instead of adding them")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: ajctl photos tag [-remove] ID TAG...")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid photo ID %q", fs.Arg(0))
+	}
+	req := client.BulkTagRequest{PhotoIDs: []int32{int32(id)}}
+	if *remove {
+		req.Remove = fs.Args()[1:]
+	} else {
+		req.Add = fs.Args()[1:]
+	}
+	resp, err := a.client.BulkTag(ctx, req)
+	if err != nil {
+		return err
+	}
+	result := resp.Results[0]
+	if result.Error != "" {
+		return fmt.Errorf("photo %d: %s", id, result.Error)
+	}
+	return a.print(result, "ADDED\tREMOVED\tUNCHANGED", func(w io.Writer) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.Join(result.Added, ", "), strings.Join(result.Removed, ", "), strings.Join(result.Unchanged, ", "))
+	})
+}
+
+// tagList joins the titles of a tags JSON array (as photos and posts have) for tables.
+func tagList(tags json.RawMessage) string {
+	var decoded []struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(tags, &decoded); err != nil {
+		return ""
+	}
+	titles := make([]string, len(decoded))
+	for i, tag := range decoded {
+		titles[i] = tag.Title
+	}
+	return strings.Join(titles, ", ")
+}
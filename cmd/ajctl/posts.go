@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/tiredkangaroo/ajiteshcc/client"
+)
+
+// ajctl posts list
+func (a *app) listPosts(ctx context.Context, args []string) error {
+	flags("posts list").Parse(args)
+	posts, err := a.client.ListPosts(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(posts, "SLUG\tPUBLISHED\tCREATED\tTAGS", func(w io.Writer) {
+		for _, p := range posts {
+			fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", p.Slug, p.Published, p.CreatedAt.Time.Format("2006-01-02"), tagList(p.Tags))
+		}
+	})
+}
+
+// ajctl posts new [-file F] [-publish] [-tags a,b] SLUG
+func (a *app) newPost(ctx context.Context, args []string) error {
+	fs := flags("posts new")
+	file := fs.String("file", "", "read the content from this file (- for stdin) instead of $EDITOR")
+	publish := fs.Bool("publish", false, "publish the post right away")
+	tags := fs.String("tags", "", "comma separated tags")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ajctl posts new [flags] SLUG")
+	}
+	slug := fs.Arg(0)
+
+	var content string
+	switch *file {
+	case "":
+		var err error
+		if content, err = edit(slug, ""); err != nil {
+			return err
+		}
+	case "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	default:
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("the post is empty, so it wasn't created")
+	}
+
+	if err := a.client.AddPost(ctx, client.AddPostRequest{
+		Slug:      slug,
+		Published: *publish,
+		Content:   content,
+		Tags:      splitList(*tags),
+	}); err != nil {
+		return err
+	}
+	a.done("created post %s", slug)
+	return nil
+}
+
+// ajctl posts publish [-unpublish] SLUG
+func (a *app) publishPost(ctx context.Context, args []string) error {
+	fs := flags("posts publish")
+	unpublish := fs.Bool("unpublish", false, "unpublish the post instead")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ajctl posts publish [-unpublish] SLUG")
+	}
+	published := !*unpublish
+	post, err := a.client.UpdatePost(ctx, fs.Arg(0), client.UpdatePostRequest{Published: &published})
+	if err != nil {
+		return err
+	}
+	if post.Published {
+		a.done("published %s", post.Slug)
+	} else {
+		a.done("unpublished %s", post.Slug)
+	}
+	return a.printResult(post)
+}
+
+// ajctl posts edit SLUG
+func (a *app) editPost(ctx context.Context, args []string) error {
+	fs := flags("posts edit")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ajctl posts edit SLUG")
+	}
+	post, err := a.client.GetPost(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	content, err := edit(post.Slug, post.Content)
+	if err != nil {
+		return err
+	}
+	if content == post.Content {
+		a.done("no changes to %s", post.Slug)
+		return nil
+	} else if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("the post is empty, so it wasn't saved (use the API to delete posts)")
+	}
+	updated, err := a.client.UpdatePost(ctx, post.Slug, client.UpdatePostRequest{Content: &content})
+	if err != nil {
+		return err
+	}
+	a.done("saved %s", updated.Slug)
+	return a.printResult(updated)
+}
+
+// edit opens content in $VISUAL or $EDITOR (vi if neither is set) and returns what was saved.
+func edit(name, content string) (string, error) {
+	f, err := os.CreateTemp("", "ajctl-"+name+"-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// the editor may have arguments (e.g. "code --wait"), so it's run by the shell
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("run %s: %w", editor, err)
+	}
+	data, err := os.ReadFile(f.Name())
+	return string(data), err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// session is the admin session saved by login, in $XDG_CONFIG_HOME/ajctl/session.json (or the
+// platform's equivalent). it's only readable by the user, since the token is an admin credential.
+type session struct {
+	Server string `json:"server"`
+	Token  string `json:"token"` // the admin_token cookie; it expires an hour after login
+}
+
+func sessionPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ajctl", "session.json"), nil
+}
+
+// loadSession returns the saved session, or an empty one if there isn't one.
+func loadSession() (*session, error) {
+	path, err := sessionPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &session{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read session: %w", err)
+	}
+	sess := &session{}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, fmt.Errorf("read session %s: %w", path, err)
+	}
+	return sess, nil
+}
+
+func (s *session) save() error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	return nil
+}
+
+// ajctl login [-code CODE]
+func (a *app) login(ctx context.Context, args []string) error {
+	fs := flags("login")
+	code := fs.String("code", "", "TOTP code (prompted for if not given)")
+	fs.Parse(args)
+
+	if *code == "" {
+		fmt.Fprint(os.Stderr, "TOTP code: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read TOTP code: %w", err)
+		}
+		*code = strings.TrimSpace(line)
+	}
+	if err := a.client.Login(ctx, *code); err != nil {
+		return err
+	}
+	token := a.client.Token()
+	if token == "" {
+		// the cookie is Secure unless the server runs in DEBUG, so it's dropped over plain http
+		return fmt.Errorf("logged in, but %s didn't set a session cookie this client can keep (is it http without DEBUG?)", a.client.BaseURL)
+	}
+	a.session.Server, a.session.Token = a.client.BaseURL.String(), token
+	if err := a.session.save(); err != nil {
+		return err
+	}
+	a.done("logged in to %s", a.session.Server)
+	return nil
+}
+
+// ajctl logout
+func (a *app) logout(ctx context.Context, args []string) error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove session: %w", err)
+	}
+	a.done("logged out")
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tiredkangaroo/ajiteshcc/client"
+)
+
+// ajctl tags list [-tree] [-counts]
+func (a *app) listTags(ctx context.Context, args []string) error {
+	fs := flags("tags list")
+	tree := fs.Bool("tree", false, "nest tags under their parents")
+	counts := fs.Bool("counts", false, "count the photos and published posts with each tag")
+	fs.Parse(args)
+
+	switch {
+	case *tree && *counts:
+		return fmt.Errorf("-tree and -counts can't be used together")
+	case *tree:
+		roots, err := a.client.TagTree(ctx)
+		if err != nil {
+			return err
+		}
+		return a.print(roots, "TITLE\tSLUG\tCOMMENT", func(w io.Writer) {
+			var walk func(nodes []*client.TagNode, depth int)
+			walk = func(nodes []*client.TagNode, depth int) {
+				for _, n := range nodes {
+					fmt.Fprintf(w, "%s%s\t%s\t%s\n", strings.Repeat("  ", depth), n.Title, n.Slug, n.Comment.String)
+					walk(n.Children, depth+1)
+				}
+			}
+			walk(roots, 0)
+		})
+	case *counts:
+		tags, err := a.client.ListTagsWithCounts(ctx)
+		if err != nil {
+			return err
+		}
+		return a.print(tags, "TITLE\tSLUG\tPHOTOS\tPOSTS", func(w io.Writer) {
+			for _, t := range tags {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", t.Title, t.Slug, t.PhotoCount, t.PostCount)
+			}
+		})
+	}
+	tags, err := a.client.ListTags(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(tags, "TITLE\tSLUG\tCOMMENT", func(w io.Writer) {
+		for _, t := range tags {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", t.Title, t.Slug, t.Comment.String)
+		}
+	})
+}
+
+// ajctl tags merge TAG INTO
+func (a *app) mergeTags(ctx context.Context, args []string) error {
+	fs := flags("tags merge")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: ajctl tags merge TAG INTO")
+	}
+	if err := a.client.MergeTag(ctx, fs.Arg(0), fs.Arg(1)); err != nil {
+		return err
+	}
+	a.done("merged %s into %s", fs.Arg(0), fs.Arg(1))
+	return nil
+}
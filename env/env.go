@@ -1,8 +1,12 @@
 package env
 
 import (
+	"fmt"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,6 +26,32 @@ type Environment struct {
 	R2_PHOTOS_BUCKET_NAME string
 	// R2_PHOTOS_BUCKET_PUBLIC_URL is the public URL of the photos bucket (e.g., https://photos.ajitesh.cc)
 	R2_PHOTOS_BUCKET_PUBLIC_URL *url.URL
+	// R2_PHOTOS_KEY_TEMPLATE is the template used to build object keys for uploaded photos
+	// (e.g., "{name}{ext}", "{yyyy}/{mm}/{name}{ext}" or "{hash}{ext}"; see bucket.ApplyKeyTemplate)
+	R2_PHOTOS_KEY_TEMPLATE string
+	// R2_PHOTOS_COLLISION_POLICY is what happens when an upload targets an existing object key
+	// ("reject", "auto-suffix" or "overwrite-with-confirm")
+	R2_PHOTOS_COLLISION_POLICY string
+	// R2_PHOTOS_CACHE_CONTROL is the Cache-Control header uploaded photos are served with
+	R2_PHOTOS_CACHE_CONTROL string
+	// MAX_UPLOAD_SIZE is the maximum size of an uploaded photo in bytes
+	MAX_UPLOAD_SIZE int64
+	// MAX_IMAGE_DIMENSION is the maximum width or height of an uploaded photo in pixels
+	MAX_IMAGE_DIMENSION int64
+	// MAX_IMAGE_PIXELS is the maximum width*height of an uploaded photo. this protects anything that
+	// decodes photos later from decompression bombs (tiny files that declare enormous images).
+	MAX_IMAGE_PIXELS int64
+	// TRASH_RETENTION is how long trashed photos, posts, tags and objects are kept before they're
+	// permanently deleted (e.g., "720h")
+	TRASH_RETENTION time.Duration
+	// TIMEZONE is the IANA timezone photos without a capture time are dated in by their upload time
+	// (e.g., "America/New_York"). photos with a capture time always use the time on the camera.
+	TIMEZONE string
+	// IMPORT_CONCURRENCY is the number of files a bulk import processes at once, unless the import
+	// sets its own
+	IMPORT_CONCURRENCY int64
+	// JOB_WORKERS is the number of background jobs (see server/jobs.go) run at once
+	JOB_WORKERS int64
 	// TOTP_SECRET is the totp secret used for admin login
 	TOTP_SECRET string
 	// DEBUG allows for insecure behaviors. DO NOT ENABLE IN PRODUCTION
@@ -31,10 +61,20 @@ type Environment struct {
 	CORS_ALLOWED_ORIGINS string
 	// ADDR is the address the server listens on (e.g., ":8080")
 	ADDR string
+	// SHUTDOWN_TIMEOUT is how long the server waits for in-flight requests and background jobs to
+	// finish when it's stopped (SIGINT or SIGTERM) before cutting them off (e.g., "30s")
+	SHUTDOWN_TIMEOUT time.Duration
 }
 
 var DefaultEnv Environment
 
+// Err is set if a required variable isn't set. it's reported by main instead of panicking here, so
+// packages that use the environment can be imported (and tested) without one.
+var Err error
+
+// required variables that aren't set, in the order they were looked up
+var missing []string
+
 func init() {
 	godotenv.Load()
 	DefaultEnv = Environment{
@@ -46,9 +86,23 @@ func init() {
 		R2_SECRET_ACCESS_KEY:        envRequire("R2_SECRET_ACCESS_KEY"),
 		R2_PHOTOS_BUCKET_NAME:       envDefault("R2_PHOTOS_BUCKET_NAME", "photos"),
 		R2_PHOTOS_BUCKET_PUBLIC_URL: urlRequire(envRequire("R2_PHOTOS_BUCKET_PUBLIC_URL")),
+		R2_PHOTOS_KEY_TEMPLATE:      envDefault("R2_PHOTOS_KEY_TEMPLATE", "{name}{ext}"),
+		R2_PHOTOS_COLLISION_POLICY:  envDefault("R2_PHOTOS_COLLISION_POLICY", "reject"),
+		R2_PHOTOS_CACHE_CONTROL:     envDefault("R2_PHOTOS_CACHE_CONTROL", "public, max-age=86400"),
+		MAX_UPLOAD_SIZE:             intDefault("MAX_UPLOAD_SIZE", 2<<30),
+		MAX_IMAGE_DIMENSION:         intDefault("MAX_IMAGE_DIMENSION", 65535),
+		MAX_IMAGE_PIXELS:            intDefault("MAX_IMAGE_PIXELS", 500_000_000),
+		TRASH_RETENTION:             durationDefault("TRASH_RETENTION", 30*24*time.Hour),
+		TIMEZONE:                    timezoneDefault("TIMEZONE", "UTC"),
+		IMPORT_CONCURRENCY:          intDefault("IMPORT_CONCURRENCY", 4),
+		JOB_WORKERS:                 intDefault("JOB_WORKERS", 4),
 		DEBUG:                       os.Getenv("DEBUG") == "true",
 		CORS_ALLOWED_ORIGINS:        envDefault("CORS_ALLOWED_ORIGINS", "https://ajitesh.cc"),
 		ADDR:                        envRequire("ADDR"),
+		SHUTDOWN_TIMEOUT:            durationDefault("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+	if len(missing) > 0 {
+		Err = fmt.Errorf("required environment variables not set: %s", strings.Join(missing, ", "))
 	}
 }
 
@@ -60,10 +114,42 @@ func urlRequire(value string) *url.URL {
 	return v
 }
 
+func intDefault(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		panic("invalid integer for " + key + ": " + value)
+	}
+	return v
+}
+
+func durationDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		panic("invalid duration for " + key + ": " + value)
+	}
+	return v
+}
+
+func timezoneDefault(key, defaultValue string) string {
+	value := envDefault(key, defaultValue)
+	if _, err := time.LoadLocation(value); err != nil {
+		panic("invalid timezone for " + key + ": " + value)
+	}
+	return value
+}
+
 func envRequire(key string) string {
 	value := os.Getenv(key)
 	if value == "" {
-		panic("environment variable " + key + " is required but not set")
+		missing = append(missing, key)
 	}
 	return value
 }
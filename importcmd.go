@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tiredkangaroo/ajiteshcc/server"
+)
+
+// runImportCommand implements "import": a bulk import run in the foreground, e.g.
+//
+//	ajiteshcc import -source local -path ~/exports/iceland -tags iceland,2024
+//	ajiteshcc import -source bucket -path 2024/06/
+//	ajiteshcc import -resume 3
+//
+// interrupting it (ctrl-c) leaves the import resumable.
+func runImportCommand(srv *server.Server, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	source := fs.String("source", server.ImportFromLocal, "where to import from: bucket (a key prefix) or local (a directory)")
+	path := fs.String("path", "", "bucket key prefix or local directory")
+	tags := fs.String("tags", "", "comma separated tags added to every imported photo")
+	concurrency := fs.Int("concurrency", 0, "files imported at once (defaults to IMPORT_CONCURRENCY)")
+	resume := fs.Int("resume", 0, "resume the import with this ID instead of starting a new one")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	id := int32(*resume)
+	if id == 0 {
+		if *source == server.ImportFromLocal && *path == "" {
+			return fmt.Errorf("-path is required for local imports")
+		}
+		var tagList []string
+		for tag := range strings.SplitSeq(*tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tagList = append(tagList, tag)
+			}
+		}
+		imp, err := srv.CreateImport(ctx, *source, *path, tagList)
+		if err != nil {
+			return err
+		}
+		id = imp.ID
+		fmt.Printf("created import %d\n", id)
+	}
+
+	runErr := srv.RunImport(ctx, id, *concurrency)
+	files, err := srv.Queries.ListImportFiles(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSTATUS\tPHOTO\tDETAIL")
+	counts := make(map[string]int)
+	for _, f := range files {
+		counts[f.Status]++
+		photo := ""
+		if f.PhotoID.Valid {
+			photo = fmt.Sprint(f.PhotoID.Int32)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Name, f.Status, photo, f.Error.String)
+	}
+	w.Flush()
+	fmt.Printf("import %d: %d imported, %d skipped, %d failed, %d pending\n",
+		id, counts["imported"], counts["skipped"], counts["failed"], counts["pending"])
+	if runErr != nil {
+		return fmt.Errorf("import %d stopped (resume with -resume %d): %w", id, id, runErr)
+	}
+	return nil
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tiredkangaroo/ajiteshcc/bucket"
 	"github.com/tiredkangaroo/ajiteshcc/env"
 	"github.com/tiredkangaroo/ajiteshcc/gen/db"
@@ -12,22 +15,45 @@ import (
 )
 
 func main() {
+	if env.Err != nil {
+		slog.Error("environment", "error", env.Err)
+		os.Exit(1)
+	}
 	if err := bucket.Init(); err != nil {
 		slog.Error("bucket initialization", "error", err)
 		return
 	}
-	conn, err := pgx.Connect(context.Background(), env.DefaultEnv.POSTGRES_CONNECTION_URI)
+	defer bucket.Close()
+	// a pool is used since requests and background workers (e.g. the trash purger) query concurrently
+	pool, err := pgxpool.New(context.Background(), env.DefaultEnv.POSTGRES_CONNECTION_URI)
 	if err != nil {
 		slog.Error("database connection", "error", err)
 		return
 	}
-	defer conn.Close(context.Background())
+	defer pool.Close()
+	if err := pool.Ping(context.Background()); err != nil {
+		slog.Error("database connection", "error", err)
+		return
+	}
 	slog.Info("database connected successfully")
 
-	queries := db.New(conn)
-	srv := &server.Server{Conn: conn, Queries: queries}
-	if err := srv.Run(); err != nil {
-		slog.Error("server run", "error", err)
+	queries := db.New(pool)
+	srv := &server.Server{Conn: pool, Queries: queries}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(srv, os.Args[2:]); err != nil {
+			slog.Error("import", "error", err)
+			os.Exit(1)
+		}
 		return
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
+		// os.Exit skips closing the pool and bucket, which workers may still be using (see
+		// server.ErrWorkersRunning). jobs they leave running are taken back once their lease expires.
+		slog.Error("server run", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("server stopped")
 }
@@ -0,0 +1,67 @@
+-- adds tags.slug to databases created before it was in schema.sql, generating a slug for every
+-- existing tag. safe to run more than once.
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS slug TEXT;
+
+-- same as in schema.sql
+CREATE OR REPLACE FUNCTION unique_tag_slug(tag_title TEXT, own_title TEXT) RETURNS TEXT
+LANGUAGE plpgsql AS $$
+DECLARE
+    base TEXT := trim(BOTH '-' FROM regexp_replace(lower(unaccent(tag_title)), '[^a-z0-9]+', '-', 'g'));
+    candidate TEXT;
+    n INT := 1;
+BEGIN
+    IF base = '' THEN
+        base := 'tag'; -- e.g. titles written entirely in a non-latin script
+    END IF;
+    candidate := base;
+    WHILE EXISTS (SELECT 1 FROM tags t WHERE t.slug = candidate AND t.title <> own_title) LOOP
+        n := n + 1;
+        candidate := base || '-' || n;
+    END LOOP;
+    RETURN candidate;
+END;
+$$;
+
+-- same as in schema.sql
+CREATE OR REPLACE FUNCTION set_tag_slug() RETURNS TRIGGER
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP = 'INSERT' AND NEW.slug IS NULL THEN
+        NEW.slug := unique_tag_slug(NEW.title, NEW.title);
+    ELSIF TG_OP = 'UPDATE' AND NEW.title <> OLD.title AND NEW.slug = OLD.slug THEN
+        NEW.slug := unique_tag_slug(NEW.title, OLD.title);
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+-- one tag at a time, so every slug is checked against the ones generated before it
+DO $$
+DECLARE
+    tag RECORD;
+BEGIN
+    FOR tag IN SELECT title FROM tags WHERE slug IS NULL ORDER BY title LOOP
+        UPDATE tags SET slug = unique_tag_slug(tag.title, tag.title) WHERE title = tag.title;
+    END LOOP;
+END;
+$$;
+
+ALTER TABLE tags ALTER COLUMN slug SET NOT NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'tags_slug_key') THEN
+        ALTER TABLE tags ADD CONSTRAINT tags_slug_key UNIQUE (slug); -- the name schema.sql's UNIQUE gets
+    END IF;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS tags_set_slug ON tags;
+CREATE TRIGGER tags_set_slug BEFORE INSERT OR UPDATE OF title ON tags
+FOR EACH ROW EXECUTE FUNCTION set_tag_slug();
+
+COMMIT;
@@ -0,0 +1,52 @@
+-- brings photos in databases created before photos.uploaded_at and photos.taken_at were in
+-- schema.sql up to date. safe to run more than once.
+BEGIN;
+
+-- photos added before metadata keys were canonical have them the way the bucket returns them, in
+-- lowercase (e.g. "cameramodel"). they're renamed to the keys server/metadata.go sets (metadataKeys),
+-- which photo_taken_at and the stats queries read. if both spellings are there, the canonical one wins.
+WITH known(key) AS (
+    SELECT unnest(ARRAY[
+        'CreatedAt', 'LensMake', 'LensModel', 'CameraMake', 'CameraModel', 'Aperture', 'FocalLength',
+        'ISO', 'ShutterSpeed', 'ImageType', 'ImageWidth', 'ImageHeight', 'Latitude', 'Longitude',
+        'Altitude', 'ExifAvailable', 'MetadataSources', 'Title', 'Description', 'Keywords', 'Rating'
+    ])
+)
+UPDATE photos p SET metadata = (
+    SELECT jsonb_object_agg(COALESCE(k.key, m.key), m.value ORDER BY m.key = COALESCE(k.key, m.key))
+    FROM jsonb_each(p.metadata) m
+    LEFT JOIN known k ON lower(k.key) = lower(m.key)
+)
+WHERE EXISTS (
+    SELECT 1 FROM jsonb_object_keys(p.metadata) m(key)
+    JOIN known k ON lower(k.key) = lower(m.key) AND k.key <> m.key
+);
+
+-- same as in schema.sql
+CREATE OR REPLACE FUNCTION photo_taken_at(metadata JSONB) RETURNS TIMESTAMP
+LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE
+    created_at TEXT := metadata->>'CreatedAt';
+BEGIN
+    IF created_at IS NULL
+        OR created_at !~ '^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}'
+        OR created_at LIKE '0001-%' THEN
+        RETURN NULL;
+    END IF;
+    RETURN make_timestamp(
+        substr(created_at, 1, 4)::int, substr(created_at, 6, 2)::int, substr(created_at, 9, 2)::int,
+        substr(created_at, 12, 2)::int, substr(created_at, 15, 2)::int, substr(created_at, 18, 2)::float8
+    );
+EXCEPTION WHEN OTHERS THEN
+    RETURN NULL; -- out of range fields (e.g. month 13) in hand edited metadata
+END;
+$$;
+
+-- when existing photos were added isn't known, so it's left NULL for them
+ALTER TABLE photos ADD COLUMN IF NOT EXISTS uploaded_at TIMESTAMP;
+ALTER TABLE photos ALTER COLUMN uploaded_at SET DEFAULT (NOW() AT TIME ZONE 'UTC');
+
+ALTER TABLE photos ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP GENERATED ALWAYS AS (photo_taken_at(metadata)) STORED;
+CREATE INDEX IF NOT EXISTS idx_photos_taken_at ON photos(taken_at);
+
+COMMIT;
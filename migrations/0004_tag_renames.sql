@@ -0,0 +1,12 @@
+-- lets tags be renamed in databases created before photo_tags and post_tags followed renames (ON
+-- UPDATE CASCADE). safe to run more than once.
+BEGIN;
+
+ALTER TABLE photo_tags
+    DROP CONSTRAINT IF EXISTS photo_tags_tag_title_fkey,
+    ADD CONSTRAINT photo_tags_tag_title_fkey FOREIGN KEY (tag_title) REFERENCES tags(title) ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE post_tags
+    DROP CONSTRAINT IF EXISTS post_tags_tag_title_fkey,
+    ADD CONSTRAINT post_tags_tag_title_fkey FOREIGN KEY (tag_title) REFERENCES tags(title) ON DELETE CASCADE ON UPDATE CASCADE;
+
+COMMIT;
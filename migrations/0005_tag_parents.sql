@@ -0,0 +1,16 @@
+-- adds tags.parent_title to databases created before tags were nested. safe to run more than once.
+BEGIN;
+
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS parent_title TEXT REFERENCES tags(title) ON DELETE SET NULL ON UPDATE CASCADE;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'tags_check' AND conrelid = 'tags'::regclass) THEN
+        ALTER TABLE tags ADD CONSTRAINT tags_check CHECK (parent_title <> title);
+    END IF;
+END;
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_tags_parent_title ON tags(parent_title);
+
+COMMIT;
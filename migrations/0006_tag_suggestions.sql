@@ -0,0 +1,9 @@
+-- adds the trigram index tag suggestions use to databases created before it was in schema.sql.
+-- safe to run more than once.
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_tags_title_trgm ON tags USING GIN (title gin_trgm_ops);
+
+COMMIT;
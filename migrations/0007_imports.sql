@@ -0,0 +1,29 @@
+-- adds the tables bulk imports are tracked in to databases created before them. safe to run more
+-- than once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS imports (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL,
+    path TEXT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS import_files (
+    import_id INT NOT NULL REFERENCES imports(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    object_key TEXT,
+    photo_id INT REFERENCES photos(id) ON DELETE SET NULL,
+    error TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (import_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_files_status ON import_files(import_id, status);
+
+COMMIT;
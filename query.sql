@@ -1,102 +1,246 @@
 -- name: GetAllPhotosWithTags :many
-SELECT p.*, 
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
        COALESCE(
            JSONB_AGG(
                JSONB_BUILD_OBJECT(
                    'title', t.title,
-                   'comment', t.comment
+                   'comment', t.comment,
+                   'slug', t.slug
                )
            ) FILTER (WHERE t.title IS NOT NULL), '[]'
        )::jsonb AS tags
 FROM photos p
 LEFT JOIN photo_tags pt ON p.id = pt.photo_id
-LEFT JOIN tags t ON pt.tag_title = t.title
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
 GROUP BY p.id;
 
 -- name: GetPhotoByIDWithTags :one
-SELECT p.*, 
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
        COALESCE(
            JSONB_AGG(
                JSONB_BUILD_OBJECT(
                    'title', t.title,
-                   'comment', t.comment
+                   'comment', t.comment,
+                   'slug', t.slug
                )
            ) FILTER (WHERE t.title IS NOT NULL), '[]'
        )::json AS tags
 FROM photos p
 LEFT JOIN photo_tags pt ON p.id = pt.photo_id
-LEFT JOIN tags t ON pt.tag_title = t.title
-WHERE p.id = $1
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.id = $1 AND p.deleted_at IS NULL
 GROUP BY p.id;
 
 -- name: GetPhotosByTagTitle :many
-SELECT p.*,
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
          COALESCE(
               JSONB_AGG(
                 JSONB_BUILD_OBJECT(
-                     'title', t2.title,
-                     'comment', t2.comment
+                     'title', t.title,
+                     'comment', t.comment,
+                     'slug', t.slug
                 )
-              ) FILTER (WHERE t2.title IS NOT NULL), '[]'
+              ) FILTER (WHERE t.title IS NOT NULL), '[]'
          )::json AS tags
 FROM photos p
 LEFT JOIN photo_tags pt ON p.id = pt.photo_id
-LEFT JOIN tags t ON pt.tag_title = t.title
-WHERE t.title = $1
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND EXISTS (SELECT 1 FROM photo_tags f WHERE f.photo_id = p.id AND f.tag_title = $1)
 GROUP BY p.id;
 
 -- name: GetPhotosByTagTitles :many
-SELECT p.*,
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
             COALESCE(
                 JSONB_AGG(
                     JSONB_BUILD_OBJECT(
-                        'title', t2.title,
-                        'comment', t2.comment
+                        'title', t.title,
+                        'comment', t.comment,
+                        'slug', t.slug
                     )
-                ) FILTER (WHERE t2.title IS NOT NULL), '[]'
+                ) FILTER (WHERE t.title IS NOT NULL), '[]'
             )::json AS tags
 FROM photos p
 LEFT JOIN photo_tags pt ON p.id = pt.photo_id
-LEFT JOIN tags t ON pt.tag_title = t.title
-WHERE t.title = ANY($1)
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND EXISTS (SELECT 1 FROM photo_tags f WHERE f.photo_id = p.id AND f.tag_title = ANY(sqlc.arg(tag_titles)::text[]))
 GROUP BY p.id;
 
 -- name: ListTags :many
-SELECT title, comment FROM tags;
+SELECT title, comment, slug FROM tags WHERE deleted_at IS NULL;
+
+-- name: ListTagsWithParents :many
+SELECT title, comment, parent_title, slug FROM tags WHERE deleted_at IS NULL ORDER BY title;
+
+-- name: SetTagParent :execrows
+UPDATE tags SET parent_title = sqlc.narg(parent_title) WHERE title = sqlc.arg(title) AND deleted_at IS NULL;
+
+-- name: ReparentTagChildren :execrows
+-- moves every child of a tag (trashed or not) under another tag, e.g. before the tag is merged.
+UPDATE tags SET parent_title = sqlc.arg(new_parent_title) WHERE parent_title = sqlc.arg(parent_title);
+
+-- name: IsTagDescendant :one
+-- reports whether candidate is the tag or one of its descendants (i.e. making candidate the tag's
+-- parent would create a cycle). UNION stops the recursion even if a cycle already exists.
+WITH RECURSIVE descendants AS (
+    SELECT sqlc.arg(title)::text AS title
+    UNION
+    SELECT t.title FROM tags t JOIN descendants d ON t.parent_title = d.title
+)
+SELECT EXISTS (SELECT 1 FROM descendants WHERE title = sqlc.arg(candidate)::text)::bool;
+
+-- name: GetPhotosByTagTree :many
+-- photos tagged with the tag or any of its descendants.
+WITH RECURSIVE tag_tree AS (
+    SELECT title FROM tags WHERE title = sqlc.arg(tag_title) AND deleted_at IS NULL
+    UNION
+    SELECT t.title FROM tags t JOIN tag_tree tt ON t.parent_title = tt.title WHERE t.deleted_at IS NULL
+)
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
+       COALESCE(
+           JSONB_AGG(
+               JSONB_BUILD_OBJECT(
+                   'title', t.title,
+                   'comment', t.comment,
+                   'slug', t.slug
+               )
+           ) FILTER (WHERE t.title IS NOT NULL), '[]'
+       )::jsonb AS tags
+FROM photos p
+LEFT JOIN photo_tags pt ON p.id = pt.photo_id
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND EXISTS (SELECT 1 FROM photo_tags f WHERE f.photo_id = p.id AND f.tag_title IN (SELECT title FROM tag_tree))
+GROUP BY p.id;
+
+-- name: GetPostsByTagTree :many
+-- posts tagged with the tag or any of its descendants.
+WITH RECURSIVE tag_tree AS (
+    SELECT title FROM tags WHERE title = sqlc.arg(tag_title) AND deleted_at IS NULL
+    UNION
+    SELECT t.title FROM tags t JOIN tag_tree tt ON t.parent_title = tt.title WHERE t.deleted_at IS NULL
+)
+SELECT p.slug, p.published, p.content, p.created_at,
+       COALESCE(
+           JSONB_AGG(
+               JSONB_BUILD_OBJECT(
+                   'title', t.title,
+                   'comment', t.comment,
+                   'slug', t.slug
+               )
+           ) FILTER (WHERE t.title IS NOT NULL), '[]'
+       )::jsonb AS tags
+FROM posts p
+LEFT JOIN post_tags pt ON p.slug = pt.post_slug
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND (p.published OR sqlc.arg(include_unpublished)::bool)
+  AND EXISTS (SELECT 1 FROM post_tags f WHERE f.post_slug = p.slug AND f.tag_title IN (SELECT title FROM tag_tree))
+GROUP BY p.slug
+ORDER BY p.created_at DESC;
 
 -- name: ListTagsWithPhotosCount :many
 SELECT t.title,
        t.comment,
+       t.slug,
        COUNT(pt.photo_id) AS photo_count
 FROM tags t
 LEFT JOIN photo_tags pt ON t.title = pt.tag_title
+    AND pt.photo_id IN (SELECT id FROM photos WHERE deleted_at IS NULL)
+WHERE t.deleted_at IS NULL
 GROUP BY t.title, t.comment;
 
 -- name: ListTagsWithPostsCount :many
 SELECT t.title,
          t.comment,
+         t.slug,
             COUNT(pt.post_slug) AS post_count
 FROM tags t
 LEFT JOIN post_tags pt ON t.title = pt.tag_title
+    AND pt.post_slug IN (SELECT slug FROM posts WHERE deleted_at IS NULL AND published)
+WHERE t.deleted_at IS NULL
 GROUP BY t.title, t.comment;
 
+-- name: CountPhotosWithTag :one
+SELECT COUNT(*) FROM photo_tags pt
+JOIN photos p ON p.id = pt.photo_id AND p.deleted_at IS NULL
+WHERE pt.tag_title = $1;
+
+-- name: CountPublishedPostsWithTag :one
+SELECT COUNT(*) FROM post_tags pt
+JOIN posts p ON p.slug = pt.post_slug AND p.deleted_at IS NULL AND p.published
+WHERE pt.tag_title = $1;
+
+-- name: ListPhotosWithTagPage :many
+-- newest photos first.
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
+       COALESCE(
+           JSONB_AGG(
+               JSONB_BUILD_OBJECT(
+                   'title', t.title,
+                   'comment', t.comment,
+                   'slug', t.slug
+               )
+           ) FILTER (WHERE t.title IS NOT NULL), '[]'
+       )::json AS tags
+FROM photos p
+LEFT JOIN photo_tags pt ON p.id = pt.photo_id
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND EXISTS (SELECT 1 FROM photo_tags f WHERE f.photo_id = p.id AND f.tag_title = sqlc.arg(tag_title))
+GROUP BY p.id
+ORDER BY p.id DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: ListPublishedPostsWithTagPage :many
+-- newest posts first.
+SELECT p.slug, p.published, p.content, p.created_at,
+       COALESCE(
+           JSONB_AGG(
+               JSONB_BUILD_OBJECT(
+                   'title', t.title,
+                   'comment', t.comment,
+                   'slug', t.slug
+               )
+           ) FILTER (WHERE t.title IS NOT NULL), '[]'
+       )::jsonb AS tags
+FROM posts p
+LEFT JOIN post_tags pt ON p.slug = pt.post_slug
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.published AND p.deleted_at IS NULL
+  AND EXISTS (SELECT 1 FROM post_tags f WHERE f.post_slug = p.slug AND f.tag_title = sqlc.arg(tag_title))
+GROUP BY p.slug
+ORDER BY p.created_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
 -- name: CreatePost :exec
 INSERT INTO posts (slug, published, content) 
 VALUES ($1, $2, $3);
 
+-- name: UpdatePost :one
+UPDATE posts
+SET content = COALESCE(sqlc.narg(content), content),
+    published = COALESCE(sqlc.narg(published), published)
+WHERE slug = sqlc.arg(slug) AND deleted_at IS NULL
+RETURNING slug, published, content, created_at;
+
 -- name: ListPostsWithTags :many
 SELECT p.slug, p.published, p.content, p.created_at,
        COALESCE(
            JSONB_AGG(
                JSONB_BUILD_OBJECT(
                    'title', t.title,
-                   'comment', t.comment
+                   'comment', t.comment,
+                   'slug', t.slug
                )
            ) FILTER (WHERE t.title IS NOT NULL), '[]'
        )::jsonb AS tags
 FROM posts p
 LEFT JOIN post_tags pt ON p.slug = pt.post_slug
-LEFT JOIN tags t ON pt.tag_title = t.title
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
 GROUP BY p.slug;
 
 -- name: ListPublishedPostsWithTags :many
@@ -105,14 +249,15 @@ SELECT p.slug, p.published, p.content, p.created_at,
               JSONB_AGG(
                 JSONB_BUILD_OBJECT(
                      'title', t.title,
-                     'comment', t.comment
+                     'comment', t.comment,
+                     'slug', t.slug
                 )
               ) FILTER (WHERE t.title IS NOT NULL), '[]'
          )::jsonb AS tags
 FROM posts p
 LEFT JOIN post_tags pt ON p.slug = pt.post_slug
-LEFT JOIN tags t ON pt.tag_title = t.title
-WHERE p.published = TRUE
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.published = TRUE AND p.deleted_at IS NULL
 GROUP BY p.slug;
 
 -- name: GetPostBySlugWithTags :one
@@ -121,14 +266,15 @@ SELECT p.slug, p.published, p.content, p.created_at,
                 JSONB_AGG(
                     JSONB_BUILD_OBJECT(
                          'title', t.title,
-                         'comment', t.comment
+                         'comment', t.comment,
+                         'slug', t.slug
                     )
                 ) FILTER (WHERE t.title IS NOT NULL), '[]'
              )::jsonb AS tags
 FROM posts p
 LEFT JOIN post_tags pt ON p.slug = pt.post_slug
-LEFT JOIN tags t ON pt.tag_title = t.title
-WHERE p.slug = $1
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.slug = $1 AND p.deleted_at IS NULL
 GROUP BY p.slug;
 
 -- name: AddPhoto :one
@@ -137,27 +283,427 @@ VALUES ($1, $2, $3, $4)
 RETURNING id;
 
 -- name: CreateTag :exec
-INSERT INTO tags (title, comment) VALUES ($1, $2);
+INSERT INTO tags (title, comment, parent_title) VALUES ($1, $2, $3);
+
+-- name: CreateTagsIfNotExist :exec
+INSERT INTO tags (title)
+SELECT unnest($1::text[])
+ON CONFLICT (title) DO NOTHING;
+
+-- name: ResolveTagTitle :one
+-- the title of the tag (trashed or not) with the given slug or title. slugs win, since they're what
+-- routes are built from.
+SELECT title FROM tags
+WHERE slug = sqlc.arg(name) OR title = sqlc.arg(name)
+ORDER BY slug = sqlc.arg(name) DESC
+LIMIT 1;
+
+-- name: SuggestTags :many
+-- tags whose title starts with or is similar (pg_trgm) to the query. prefix matches come first, then
+-- the most used tags.
+WITH usage AS (
+    SELECT tag_title, COUNT(*) AS uses
+    FROM (
+        SELECT tag_title FROM photo_tags
+        UNION ALL
+        SELECT tag_title FROM post_tags
+    ) tagged
+    GROUP BY tag_title
+)
+SELECT t.title, t.comment, t.slug,
+       COALESCE(u.uses, 0)::bigint AS uses,
+       similarity(t.title, sqlc.arg(query)::text)::float8 AS similarity
+FROM tags t
+LEFT JOIN usage u ON u.tag_title = t.title
+WHERE t.deleted_at IS NULL
+  AND (t.title ILIKE sqlc.arg(prefix_pattern)::text OR t.title % sqlc.arg(query)::text)
+ORDER BY t.title ILIKE sqlc.arg(prefix_pattern)::text DESC, uses DESC, similarity DESC, t.title
+LIMIT sqlc.arg(row_limit);
+
+-- name: SuggestTagsForPhoto :many
+-- tags of photos similar to the given one that it doesn't have yet. photos are similar if they were
+-- taken within a day of it, with the same camera or lens, or share its tags; a tag's score is the
+-- sum of the similarity of the photos it's on.
+WITH target AS (
+    SELECT id, metadata, taken_at FROM photos WHERE id = sqlc.arg(photo_id)
+), target_tags AS (
+    SELECT tag_title FROM photo_tags WHERE photo_id = sqlc.arg(photo_id)
+), similar AS (
+    SELECT p.id,
+           CASE WHEN ABS(EXTRACT(EPOCH FROM p.taken_at - t.taken_at)) < 86400 THEN 3 ELSE 0 END
+           + CASE WHEN COALESCE(p.metadata->>'CameraModel', '') <> ''
+                   AND p.metadata->>'CameraModel' = t.metadata->>'CameraModel' THEN 1 ELSE 0 END
+           + CASE WHEN COALESCE(p.metadata->>'LensModel', '') <> ''
+                   AND p.metadata->>'LensModel' = t.metadata->>'LensModel' THEN 1 ELSE 0 END
+           + 2 * (SELECT COUNT(*) FROM photo_tags pt
+                  WHERE pt.photo_id = p.id AND pt.tag_title IN (SELECT tag_title FROM target_tags)) AS score
+    FROM photos p, target t
+    WHERE p.id <> t.id AND p.deleted_at IS NULL
+)
+SELECT tg.title, tg.comment, tg.slug, SUM(s.score)::float8 AS score
+FROM similar s
+JOIN photo_tags pt ON pt.photo_id = s.id
+JOIN tags tg ON tg.title = pt.tag_title AND tg.deleted_at IS NULL
+WHERE s.score > 0 AND pt.tag_title NOT IN (SELECT tag_title FROM target_tags)
+GROUP BY tg.title
+ORDER BY score DESC, tg.title
+LIMIT sqlc.arg(row_limit);
+
+-- name: GetTag :one
+SELECT title, comment, parent_title, slug FROM tags WHERE title = $1 AND deleted_at IS NULL;
 
--- name: DeleteTag :exec
+-- name: UpdateTag :one
+-- photo_tags and post_tags follow renames through ON UPDATE CASCADE. renamed tags get a new slug
+-- (see set_tag_slug) unless one is given.
+UPDATE tags
+SET title = sqlc.arg(new_title),
+    comment = CASE WHEN sqlc.arg(set_comment)::bool THEN sqlc.narg(comment) ELSE comment END,
+    slug = COALESCE(sqlc.narg(slug), slug)
+WHERE title = sqlc.arg(title) AND deleted_at IS NULL
+RETURNING title, comment, slug;
+
+-- name: MergePhotoTags :exec
+INSERT INTO photo_tags (photo_id, tag_title)
+SELECT photo_id, sqlc.arg(into_title) FROM photo_tags WHERE tag_title = sqlc.arg(from_title)
+ON CONFLICT (photo_id, tag_title) DO NOTHING;
+
+-- name: MergePostTags :exec
+INSERT INTO post_tags (post_slug, tag_title)
+SELECT post_slug, sqlc.arg(into_title) FROM post_tags WHERE tag_title = sqlc.arg(from_title)
+ON CONFLICT (post_slug, tag_title) DO NOTHING;
+
+-- name: DeleteTagPermanently :execrows
+-- photo_tags and post_tags rows are removed through ON DELETE CASCADE.
 DELETE FROM tags WHERE title = $1;
 
--- name: AddTagToPhoto :exec
-INSERT INTO photo_tags (photo_id, tag_title) VALUES ($1, $2);
+-- name: DeleteTag :execrows
+UPDATE tags SET deleted_at = NOW() WHERE title = $1 AND deleted_at IS NULL;
+
+-- name: ListTrashedTagTitles :many
+SELECT title FROM tags WHERE title = ANY(sqlc.arg(titles)::text[]) AND deleted_at IS NOT NULL;
+
+-- name: AddTagToPhoto :execrows
+-- nothing is added if the photo already has the tag, or if the photo or tag doesn't exist (or is in
+-- the trash).
+INSERT INTO photo_tags (photo_id, tag_title)
+SELECT p.id, t.title FROM photos p, tags t
+WHERE p.id = sqlc.arg(photo_id) AND p.deleted_at IS NULL
+  AND t.title = sqlc.arg(tag_title) AND t.deleted_at IS NULL
+ON CONFLICT (photo_id, tag_title) DO NOTHING;
 
 -- name: AddTagsToPhoto :exec
 INSERT INTO photo_tags (photo_id, tag_title)
-SELECT $1, unnest($2::text[]);
+SELECT $1, unnest($2::text[])
+ON CONFLICT (photo_id, tag_title) DO NOTHING;
 
--- name: AddTagToPost :exec
-INSERT INTO post_tags (post_slug, tag_title) VALUES ($1, $2);
+-- name: AddTagToPost :execrows
+-- nothing is added if the post already has the tag, or if the post or tag doesn't exist (or is in
+-- the trash).
+INSERT INTO post_tags (post_slug, tag_title)
+SELECT p.slug, t.title FROM posts p, tags t
+WHERE p.slug = sqlc.arg(post_slug) AND p.deleted_at IS NULL
+  AND t.title = sqlc.arg(tag_title) AND t.deleted_at IS NULL
+ON CONFLICT (post_slug, tag_title) DO NOTHING;
 
 -- name: AddTagsToPost :exec
 INSERT INTO post_tags (post_slug, tag_title)
-SELECT $1, unnest($2::text[]);
+SELECT $1, unnest($2::text[])
+ON CONFLICT (post_slug, tag_title) DO NOTHING;
+
+-- name: AddMissingTagsToPhoto :many
+-- adds the tags the photo doesn't already have, returning the ones added.
+INSERT INTO photo_tags (photo_id, tag_title)
+SELECT sqlc.arg(photo_id), unnest(sqlc.arg(tag_titles)::text[])
+ON CONFLICT (photo_id, tag_title) DO NOTHING
+RETURNING tag_title;
+
+-- name: AddMissingTagsToPost :many
+-- adds the tags the post doesn't already have, returning the ones added.
+INSERT INTO post_tags (post_slug, tag_title)
+SELECT sqlc.arg(post_slug), unnest(sqlc.arg(tag_titles)::text[])
+ON CONFLICT (post_slug, tag_title) DO NOTHING
+RETURNING tag_title;
+
+-- name: RemoveTagsFromPhoto :many
+DELETE FROM photo_tags WHERE photo_id = sqlc.arg(photo_id) AND tag_title = ANY(sqlc.arg(tag_titles)::text[])
+RETURNING tag_title;
+
+-- name: RemoveTagsFromPost :many
+DELETE FROM post_tags WHERE post_slug = sqlc.arg(post_slug) AND tag_title = ANY(sqlc.arg(tag_titles)::text[])
+RETURNING tag_title;
+
+-- name: ListExistingPhotoIDs :many
+SELECT id FROM photos WHERE id = ANY(sqlc.arg(ids)::int[]) AND deleted_at IS NULL;
+
+-- name: ListExistingPostSlugs :many
+SELECT slug FROM posts WHERE slug = ANY(sqlc.arg(slugs)::text[]) AND deleted_at IS NULL;
+
+-- name: ListExistingTagTitles :many
+SELECT title FROM tags WHERE title = ANY(sqlc.arg(titles)::text[]) AND deleted_at IS NULL;
 
 -- name: RemoveTagFromPost :exec
 DELETE FROM post_tags WHERE post_slug = $1 AND tag_title = $2;
 
 -- name: RemoveTagFromPhoto :exec
-DELETE FROM photo_tags WHERE photo_id = $1 AND tag_title = $2;
\ No newline at end of file
+DELETE FROM photo_tags WHERE photo_id = $1 AND tag_title = $2;
+
+-- name: CountPhotosWithURL :one
+SELECT COUNT(*) FROM photos WHERE photo_url = $1;
+
+-- name: UpdatePhotoURL :execrows
+UPDATE photos SET photo_url = sqlc.arg(new_url) WHERE photo_url = sqlc.arg(old_url);
+
+-- name: DeletePhoto :execrows
+UPDATE photos SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: DeletePost :execrows
+UPDATE posts SET deleted_at = NOW() WHERE slug = $1 AND deleted_at IS NULL;
+
+-- name: ListDeletedPhotos :many
+SELECT id, title, photo_url, comment, metadata, deleted_at, uploaded_at, taken_at
+FROM photos
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
+
+-- name: ListDeletedPosts :many
+SELECT slug, published, content, created_at, deleted_at
+FROM posts
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
+
+-- name: ListDeletedTags :many
+SELECT title, comment, deleted_at, parent_title, slug
+FROM tags
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
+
+-- name: RestorePhoto :execrows
+UPDATE photos SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- name: RestorePost :execrows
+UPDATE posts SET deleted_at = NULL WHERE slug = $1 AND deleted_at IS NOT NULL;
+
+-- name: RestoreTag :execrows
+UPDATE tags SET deleted_at = NULL WHERE title = $1 AND deleted_at IS NOT NULL;
+
+-- name: PurgeDeletedPhotos :execrows
+DELETE FROM photos
+WHERE deleted_at < NOW() - (sqlc.arg(retention_seconds)::bigint * INTERVAL '1 second');
+
+-- name: PurgeDeletedPosts :execrows
+DELETE FROM posts
+WHERE deleted_at < NOW() - (sqlc.arg(retention_seconds)::bigint * INTERVAL '1 second');
+
+-- name: PurgeDeletedTags :execrows
+DELETE FROM tags
+WHERE deleted_at < NOW() - (sqlc.arg(retention_seconds)::bigint * INTERVAL '1 second');
+
+-- name: UpdatePhotoMetadata :one
+UPDATE photos SET metadata = $2 WHERE id = $1 AND deleted_at IS NULL
+RETURNING photo_url;
+
+-- name: UpdatePhotoMetadataByURL :execrows
+UPDATE photos SET metadata = $2 WHERE photo_url = $1;
+
+-- name: CreateImport :one
+INSERT INTO imports (source, path, tags) VALUES ($1, $2, $3)
+RETURNING id, source, path, tags, status, error, created_at, finished_at, heartbeat_at;
+
+-- name: GetImport :one
+SELECT id, source, path, tags, status, error, created_at, finished_at, heartbeat_at FROM imports WHERE id = $1;
+
+-- name: ListImports :many
+SELECT id, source, path, tags, status, error, created_at, finished_at, heartbeat_at FROM imports ORDER BY id DESC;
+
+-- name: StartImport :execrows
+UPDATE imports SET status = 'running', error = NULL, finished_at = NULL, heartbeat_at = NOW()
+WHERE id = $1 AND status <> 'running';
+
+-- name: TouchImport :exec
+UPDATE imports SET heartbeat_at = NOW() WHERE id = $1 AND status = 'running';
+
+-- name: FinishImport :exec
+UPDATE imports SET status = $2, error = $3, finished_at = NOW() WHERE id = $1;
+
+-- name: InterruptStaleImports :execrows
+-- running imports whose process stopped without finishing them (their heartbeat is older than the lease).
+UPDATE imports SET status = 'interrupted'
+WHERE status = 'running'
+  AND (heartbeat_at IS NULL OR heartbeat_at < NOW() - sqlc.arg(lease_seconds)::bigint * INTERVAL '1 second');
+
+-- name: AddImportFiles :exec
+INSERT INTO import_files (import_id, name)
+SELECT $1, unnest($2::text[])
+ON CONFLICT (import_id, name) DO NOTHING;
+
+-- name: ListUnfinishedImportFiles :many
+SELECT name FROM import_files
+WHERE import_id = $1 AND status IN ('pending', 'failed')
+ORDER BY name;
+
+-- name: SetImportFileResult :exec
+UPDATE import_files SET status = $3, object_key = $4, photo_id = $5, error = $6, updated_at = NOW()
+WHERE import_id = $1 AND name = $2;
+
+-- name: ListImportFiles :many
+SELECT import_id, name, status, object_key, photo_id, error, updated_at FROM import_files
+WHERE import_id = $1
+ORDER BY name;
+
+-- name: CountImportFilesByStatus :many
+SELECT status, COUNT(*) AS count FROM import_files WHERE import_id = $1 GROUP BY status;
+
+-- name: EnqueueJob :one
+INSERT INTO jobs (kind, payload, run_at, max_attempts)
+VALUES ($1, $2, COALESCE(sqlc.narg(run_at)::timestamp, NOW()), $3)
+RETURNING id;
+
+-- name: ClaimJob :one
+UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_at = NOW()
+WHERE id = (
+    SELECT id FROM jobs
+    WHERE status = 'pending' AND run_at <= NOW()
+    ORDER BY run_at, id
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, kind, payload, status, attempts, max_attempts, run_at, last_error, locked_at, created_at, finished_at;
+
+-- name: CompleteJob :exec
+UPDATE jobs SET status = 'done', locked_at = NULL, finished_at = NOW() WHERE id = $1;
+
+-- name: RescheduleJob :exec
+UPDATE jobs
+SET status = 'pending', locked_at = NULL, last_error = $2,
+    run_at = NOW() + sqlc.arg(backoff_seconds)::bigint * INTERVAL '1 second'
+WHERE id = $1;
+
+-- name: FailJob :exec
+UPDATE jobs SET status = 'failed', locked_at = NULL, last_error = $2, finished_at = NOW() WHERE id = $1;
+
+-- name: TouchJob :exec
+UPDATE jobs SET locked_at = NOW() WHERE id = $1 AND status = 'running';
+
+-- name: RequeueExpiredJobs :execrows
+-- running jobs whose worker stopped without finishing them (their lock is older than the lease).
+UPDATE jobs SET status = 'pending', locked_at = NULL
+WHERE status = 'running'
+  AND (locked_at IS NULL OR locked_at < NOW() - sqlc.arg(lease_seconds)::bigint * INTERVAL '1 second');
+
+-- name: GetJob :one
+SELECT id, kind, payload, status, attempts, max_attempts, run_at, last_error, locked_at, created_at, finished_at
+FROM jobs WHERE id = $1;
+
+-- name: ListJobs :many
+SELECT id, kind, payload, status, attempts, max_attempts, run_at, last_error, locked_at, created_at, finished_at
+FROM jobs
+WHERE sqlc.arg(status)::text = '' OR status = sqlc.arg(status)::text
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: CountJobsByStatus :many
+SELECT status, COUNT(*) AS count FROM jobs GROUP BY status;
+
+-- name: RetryFailedJob :execrows
+UPDATE jobs SET status = 'pending', attempts = 0, run_at = NOW(), finished_at = NULL
+WHERE id = $1 AND status = 'failed';
+
+-- name: CountPhotos :one
+SELECT COUNT(*) FROM photos WHERE deleted_at IS NULL;
+
+-- name: CountPhotosByCamera :many
+SELECT COALESCE(metadata->>'CameraMake', '')::text AS camera_make,
+       (metadata->>'CameraModel')::text AS camera_model,
+       COUNT(*) AS count
+FROM photos
+WHERE deleted_at IS NULL AND COALESCE(metadata->>'CameraModel', '') <> ''
+GROUP BY 1, 2
+ORDER BY count DESC, camera_model;
+
+-- name: CountPhotosByLens :many
+SELECT COALESCE(metadata->>'LensMake', '')::text AS lens_make,
+       (metadata->>'LensModel')::text AS lens_model,
+       COUNT(*) AS count
+FROM photos
+WHERE deleted_at IS NULL AND COALESCE(metadata->>'LensModel', '') <> ''
+GROUP BY 1, 2
+ORDER BY count DESC, lens_model;
+
+-- name: CountPhotosByFocalLength :many
+-- metadata values are strings and can be edited by hand, so they're only cast once they're known
+-- to be numbers (CASE guarantees the check happens before the cast).
+WITH v AS (
+    SELECT CASE WHEN metadata->>'FocalLength' ~ '^[0-9]+(\.[0-9]+)?$'
+                THEN (metadata->>'FocalLength')::numeric END AS value
+    FROM photos WHERE deleted_at IS NULL
+)
+SELECT ROUND(value)::int AS focal_length, COUNT(*) AS count
+FROM v WHERE value > 0
+GROUP BY 1 ORDER BY 1;
+
+-- name: CountPhotosByAperture :many
+WITH v AS (
+    SELECT CASE WHEN metadata->>'Aperture' ~ '^[0-9]+(\.[0-9]+)?$'
+                THEN (metadata->>'Aperture')::numeric END AS value
+    FROM photos WHERE deleted_at IS NULL
+)
+SELECT ROUND(value, 1)::float8 AS aperture, COUNT(*) AS count
+FROM v WHERE value > 0
+GROUP BY 1 ORDER BY 1;
+
+-- name: CountPhotosByISO :many
+WITH v AS (
+    SELECT CASE WHEN metadata->>'ISO' ~ '^[0-9]{1,9}$'
+                THEN (metadata->>'ISO')::int END AS value
+    FROM photos WHERE deleted_at IS NULL
+)
+SELECT value::int AS iso, COUNT(*) AS count
+FROM v WHERE value > 0
+GROUP BY 1 ORDER BY 1;
+
+-- name: CountPhotosByMonth :many
+-- photos without a capture time are left out.
+SELECT TO_CHAR(taken_at, 'YYYY-MM')::text AS month, COUNT(*) AS count
+FROM photos
+WHERE deleted_at IS NULL AND taken_at IS NOT NULL
+GROUP BY 1 ORDER BY 1;
+
+-- name: PhotoArchive :many
+-- photos are dated by when they were taken (local time), or when they were uploaded (converted to
+-- the timezone) if that's unknown. photos with neither (added before uploads were recorded, and
+-- without a capture time) are left out.
+SELECT EXTRACT(YEAR FROM taken)::int AS year,
+       EXTRACT(MONTH FROM taken)::int AS month,
+       EXTRACT(DAY FROM taken)::int AS day,
+       COUNT(*) AS count
+FROM (
+    SELECT COALESCE(taken_at, uploaded_at AT TIME ZONE 'UTC' AT TIME ZONE sqlc.arg(timezone)::text) AS taken
+    FROM photos
+    WHERE deleted_at IS NULL
+) p
+WHERE taken IS NOT NULL
+GROUP BY 1, 2, 3
+ORDER BY 1 DESC, 2 DESC, 3 DESC;
+
+-- name: ListPhotosTakenBetween :many
+-- see PhotoArchive for how photos are dated. undated photos are never in the range.
+SELECT p.id, p.title, p.photo_url, p.comment, p.metadata,
+       COALESCE(p.taken_at, p.uploaded_at AT TIME ZONE 'UTC' AT TIME ZONE sqlc.arg(timezone)::text)::timestamp AS taken_at,
+       (p.taken_at IS NULL)::bool AS dated_by_upload,
+       COALESCE(
+           JSONB_AGG(
+               JSONB_BUILD_OBJECT(
+                   'title', t.title,
+                   'comment', t.comment,
+                   'slug', t.slug
+               )
+           ) FILTER (WHERE t.title IS NOT NULL), '[]'
+       )::jsonb AS tags
+FROM photos p
+LEFT JOIN photo_tags pt ON p.id = pt.photo_id
+LEFT JOIN tags t ON pt.tag_title = t.title AND t.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND COALESCE(p.taken_at, p.uploaded_at AT TIME ZONE 'UTC' AT TIME ZONE sqlc.arg(timezone)::text) >= sqlc.arg(start_time)::timestamp
+  AND COALESCE(p.taken_at, p.uploaded_at AT TIME ZONE 'UTC' AT TIME ZONE sqlc.arg(timezone)::text) < sqlc.arg(end_time)::timestamp
+GROUP BY p.id
+ORDER BY 6, p.id;
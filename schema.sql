@@ -1,35 +1,148 @@
+-- schema.sql creates a new database. databases created from an earlier version are brought up to
+-- date by running the files in migrations/ in order.
+
+-- photo_taken_at returns the local (wall clock) time a photo was taken from its CreatedAt metadata
+-- ("2006-01-02 15:04:05 -0700 ..."), or NULL if it's missing or invalid. the EXIF offset is left out
+-- on purpose: a photo taken at 23:30 in Tokyo belongs to that day, wherever it's viewed from.
+CREATE FUNCTION photo_taken_at(metadata JSONB) RETURNS TIMESTAMP
+LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE
+    created_at TEXT := metadata->>'CreatedAt';
+BEGIN
+    IF created_at IS NULL
+        OR created_at !~ '^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}'
+        OR created_at LIKE '0001-%' THEN
+        RETURN NULL;
+    END IF;
+    RETURN make_timestamp(
+        substr(created_at, 1, 4)::int, substr(created_at, 6, 2)::int, substr(created_at, 9, 2)::int,
+        substr(created_at, 12, 2)::int, substr(created_at, 15, 2)::int, substr(created_at, 18, 2)::float8
+    );
+EXCEPTION WHEN OTHERS THEN
+    RETURN NULL; -- out of range fields (e.g. month 13) in hand edited metadata
+END;
+$$;
+
 CREATE TABLE photos (
     id SERIAL PRIMARY KEY,
     title TEXT,
     photo_url TEXT NOT NULL,
     comment TEXT,
-    metadata JSONB NOT NULL DEFAULT '{}'::JSONB
+    metadata JSONB NOT NULL DEFAULT '{}'::JSONB,
+    deleted_at TIMESTAMP, -- set when the photo is moved to the trash
+    uploaded_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC'), -- when the photo was added (UTC); NULL for photos added before it was recorded
+    taken_at TIMESTAMP GENERATED ALWAYS AS (photo_taken_at(metadata)) STORED -- local time the photo was taken, if known
 );
 
 CREATE TABLE posts (
     slug TEXT PRIMARY KEY,
     published BOOLEAN NOT NULL DEFAULT FALSE,
     content TEXT NOT NULL,
-    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMP -- set when the post is moved to the trash
 );
 
 CREATE TABLE tags (
-    title TEXT PRIMARY KEY,
-    comment TEXT
+    title TEXT PRIMARY KEY, -- display title; free-form
+    comment TEXT,
+    deleted_at TIMESTAMP, -- set when the tag is moved to the trash
+    parent_title TEXT REFERENCES tags(title) ON DELETE SET NULL ON UPDATE CASCADE, -- e.g. "japan" for "kyoto"; cycles are prevented by the server
+    slug TEXT NOT NULL UNIQUE, -- URL safe name used in routes; set by set_tag_slug
+    CHECK (parent_title <> title)
 );
 
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unique_tag_slug returns a URL safe slug for a tag title that no other tag uses, e.g. "Café Tokyo"
+-- becomes "cafe-tokyo", or "cafe-tokyo-2" if that's taken. own_title is the title of the tag the
+-- slug is for (its current slug doesn't count as taken).
+CREATE FUNCTION unique_tag_slug(tag_title TEXT, own_title TEXT) RETURNS TEXT
+LANGUAGE plpgsql AS $$
+DECLARE
+    base TEXT := trim(BOTH '-' FROM regexp_replace(lower(unaccent(tag_title)), '[^a-z0-9]+', '-', 'g'));
+    candidate TEXT;
+    n INT := 1;
+BEGIN
+    IF base = '' THEN
+        base := 'tag'; -- e.g. titles written entirely in a non-latin script
+    END IF;
+    candidate := base;
+    WHILE EXISTS (SELECT 1 FROM tags t WHERE t.slug = candidate AND t.title <> own_title) LOOP
+        n := n + 1;
+        candidate := base || '-' || n;
+    END LOOP;
+    RETURN candidate;
+END;
+$$;
+
+-- set_tag_slug generates slugs for new tags (unless one is given) and for renamed tags (unless the
+-- slug is changed along with the title).
+CREATE FUNCTION set_tag_slug() RETURNS TRIGGER
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP = 'INSERT' AND NEW.slug IS NULL THEN
+        NEW.slug := unique_tag_slug(NEW.title, NEW.title);
+    ELSIF TG_OP = 'UPDATE' AND NEW.title <> OLD.title AND NEW.slug = OLD.slug THEN
+        NEW.slug := unique_tag_slug(NEW.title, OLD.title);
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+CREATE TRIGGER tags_set_slug BEFORE INSERT OR UPDATE OF title ON tags
+FOR EACH ROW EXECUTE FUNCTION set_tag_slug();
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_tags_title_trgm ON tags USING GIN (title gin_trgm_ops); -- fuzzy and prefix tag suggestions
+
 CREATE TABLE photo_tags (
     photo_id INT NOT NULL REFERENCES photos(id) ON DELETE CASCADE,
-    tag_title TEXT NOT NULL REFERENCES tags(title) ON DELETE CASCADE,
+    tag_title TEXT NOT NULL REFERENCES tags(title) ON DELETE CASCADE ON UPDATE CASCADE, -- renaming a tag renames it here
     PRIMARY KEY (photo_id, tag_title)
 );
 
 CREATE TABLE post_tags (
     post_slug TEXT NOT NULL REFERENCES posts(slug) ON DELETE CASCADE,
-    tag_title TEXT NOT NULL REFERENCES tags(title) ON DELETE CASCADE,
+    tag_title TEXT NOT NULL REFERENCES tags(title) ON DELETE CASCADE ON UPDATE CASCADE, -- renaming a tag renames it here
     PRIMARY KEY (post_slug, tag_title)
 );
 
+CREATE TABLE imports (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL, -- 'bucket' (an object key prefix) or 'local' (a directory on the server)
+    path TEXT NOT NULL, -- the prefix or directory being imported
+    tags TEXT[] NOT NULL DEFAULT '{}', -- tags added to every imported photo
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, running, interrupted, done or failed
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    heartbeat_at TIMESTAMP -- refreshed while the import runs; see server/jobs.go for leases
+);
+
+CREATE TABLE import_files (
+    import_id INT NOT NULL REFERENCES imports(id) ON DELETE CASCADE,
+    name TEXT NOT NULL, -- object key (bucket) or path relative to the directory (local)
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, imported, skipped or failed
+    object_key TEXT, -- key of the photo's object once it's in the bucket
+    photo_id INT REFERENCES photos(id) ON DELETE SET NULL,
+    error TEXT, -- why the file was skipped or failed
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (import_id, name)
+);
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL, -- what the job does (e.g. 'extract_metadata'), see server/jobs.go
+    payload JSONB NOT NULL DEFAULT '{}'::JSONB,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, running, done or failed
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_at TIMESTAMP NOT NULL DEFAULT NOW(), -- the job isn't run before this (used for scheduling and backoff)
+    last_error TEXT,
+    locked_at TIMESTAMP, -- when a worker claimed the job, refreshed while it runs; see server/jobs.go for leases
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
 
 CREATE INDEX idx_photo_tags_photo_id ON photo_tags(photo_id);
 CREATE INDEX idx_photo_tags_tag_title ON photo_tags(tag_title);
@@ -38,4 +151,12 @@ CREATE INDEX idx_tags_title ON tags(title);
 CREATE INDEX idx_post_tags_post_slug ON post_tags(post_slug);
 CREATE INDEX idx_post_tags_tag_title ON post_tags(tag_title);
 CREATE INDEX idx_posts_published ON posts(published);
-CREATE INDEX idx_posts_slug ON posts(slug);
\ No newline at end of file
+CREATE INDEX idx_posts_slug ON posts(slug);
+CREATE INDEX idx_photos_deleted_at ON photos(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_posts_deleted_at ON posts(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_tags_deleted_at ON tags(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_import_files_status ON import_files(import_id, status);
+CREATE INDEX idx_jobs_runnable ON jobs(run_at) WHERE status = 'pending';
+CREATE INDEX idx_jobs_status ON jobs(status, id);
+CREATE INDEX idx_photos_taken_at ON photos(taken_at);
+CREATE INDEX idx_tags_parent_title ON tags(parent_title);
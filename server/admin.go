@@ -37,7 +37,7 @@ func (s *Server) adminLoginHandler() echo.HandlerFunc {
 		jwtToken, err := issueJWTWithTOTP(req.TOTP)
 		if err != nil {
 			slog.Error("issue jwt with totp", "error", err)
-			return c.String(403, "forbidden: invalid TOTP code")
+			return forbidden("invalid TOTP code")
 		}
 		c.SetCookie(&http.Cookie{
 			Name:     "admin_token",
@@ -55,7 +55,7 @@ func (s *Server) adminLoginHandler() echo.HandlerFunc {
 func RequireAdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if !isAdmin(c) {
-			return c.String(403, "forbidden: admin access required")
+			return forbidden("admin access required")
 		}
 		return next(c)
 	}
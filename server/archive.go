@@ -0,0 +1,106 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+type archiveYear struct {
+	Year   int32          `json:"year"`
+	Count  int64          `json:"count"`
+	Months []archiveMonth `json:"months"` // newest first
+}
+
+type archiveMonth struct {
+	Month int32        `json:"month"`
+	Count int64        `json:"count"`
+	Days  []archiveDay `json:"days"` // newest first
+}
+
+type archiveDay struct {
+	Day   int32 `json:"day"`
+	Count int64 `json:"count"`
+}
+
+type archivePhotoDay struct {
+	Day    int                            `json:"day"`
+	Photos []db.ListPhotosTakenBetweenRow `json:"photos"`
+}
+
+// GET /api/v1/photos/archive
+//
+// photo counts by year, month and day taken. photos are dated by the local time on the camera, or
+// by their upload time in TIMEZONE if they have no capture time. photos with neither (added before
+// upload times were recorded) aren't in the archive.
+func (s *Server) getPhotoArchive(c echo.Context) error {
+	rows, err := s.Queries.PhotoArchive(c.Request().Context(), env.DefaultEnv.TIMEZONE)
+	if err != nil {
+		slog.Error("photo archive", "error", err)
+		return internalError()
+	}
+	// rows are sorted by year, month and day (newest first)
+	years := []archiveYear{}
+	for _, row := range rows {
+		if len(years) == 0 || years[len(years)-1].Year != row.Year {
+			years = append(years, archiveYear{Year: row.Year})
+		}
+		year := &years[len(years)-1]
+		if len(year.Months) == 0 || year.Months[len(year.Months)-1].Month != row.Month {
+			year.Months = append(year.Months, archiveMonth{Month: row.Month})
+		}
+		month := &year.Months[len(year.Months)-1]
+		month.Days = append(month.Days, archiveDay{Day: row.Day, Count: row.Count})
+		month.Count += row.Count
+		year.Count += row.Count
+	}
+	return c.JSON(200, echo.Map{
+		"timezone": env.DefaultEnv.TIMEZONE,
+		"years":    years,
+	})
+}
+
+// GET /api/v1/photos/archive/:year/:month
+//
+// the photos taken in a month, grouped by day (oldest first). see getPhotoArchive for how photos
+// are dated; undated photos are never listed.
+func (s *Server) getPhotoArchiveMonthHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Year  int `param:"year"`
+		Month int `param:"month"`
+	}) error {
+		if req.Year < 1 || req.Year > 9999 || req.Month < 1 || req.Month > 12 {
+			return badRequest("invalid year or month")
+		}
+		// timestamps in the query are wall clock times, so the range is built in UTC to avoid
+		// shifting it by the server's timezone
+		start := time.Date(req.Year, time.Month(req.Month), 1, 0, 0, 0, 0, time.UTC)
+		photos, err := s.Queries.ListPhotosTakenBetween(c.Request().Context(), db.ListPhotosTakenBetweenParams{
+			Timezone:  env.DefaultEnv.TIMEZONE,
+			StartTime: pgtype.Timestamp{Time: start, Valid: true},
+			EndTime:   pgtype.Timestamp{Time: start.AddDate(0, 1, 0), Valid: true},
+		})
+		if err != nil {
+			slog.Error("list photos taken between", "error", err)
+			return internalError()
+		}
+		days := []archivePhotoDay{}
+		for _, photo := range photos {
+			d := photo.TakenAt.Time.Day()
+			if len(days) == 0 || days[len(days)-1].Day != d {
+				days = append(days, archivePhotoDay{Day: d})
+			}
+			days[len(days)-1].Photos = append(days[len(days)-1].Photos, photo)
+		}
+		return c.JSON(200, echo.Map{
+			"year":     req.Year,
+			"month":    req.Month,
+			"timezone": env.DefaultEnv.TIMEZONE,
+			"days":     days,
+		})
+	})
+}
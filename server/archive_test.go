@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// photos added before upload times were recorded, without a capture time, have no date
+func TestPhotoArchiveUndatedPhoto(t *testing.T) {
+	s := newTestServer(t)
+	var id int32
+	if err := s.Conn.QueryRow(context.Background(),
+		"INSERT INTO photos (photo_url, uploaded_at) VALUES ($1, NULL) RETURNING id", unique("undated.jpg"),
+	).Scan(&id); err != nil {
+		t.Fatalf("add undated photo: %v", err)
+	}
+	t.Cleanup(func() { s.Conn.Exec(context.Background(), "DELETE FROM photos WHERE id = $1", id) })
+
+	s.expect(t, 200, http.MethodGet, "/api/v1/photos/archive", nil)
+	s.expect(t, 200, http.MethodGet, time.Now().Format("/api/v1/photos/archive/2006/1"), nil)
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"github.com/tiredkangaroo/ajiteshcc/gen/db"
 )
@@ -33,10 +36,10 @@ func (s *Server) getPostBySlug(c echo.Context) error {
 	post, err := s.Queries.GetPostBySlugWithTags(c.Request().Context(), slug)
 	if err != nil {
 		slog.Error("get post by slug with tags", "error", err)
-		return c.JSON(404, echo.Map{"error": "post not found"})
+		return notFound("post not found")
 	}
 	if !post.Published && !c.Get("is_admin").(bool) { // not published and not admin -- forbidden
-		return c.JSON(404, echo.Map{"error": "post not found"})
+		return notFound("post not found")
 	}
 	return c.JSON(http.StatusOK, post) // either published or user is admin
 }
@@ -52,7 +55,7 @@ func (s *Server) addPostHandler() echo.HandlerFunc {
 		tx, err := s.Conn.Begin(c.Request().Context())
 		if err != nil {
 			slog.Error("begin transaction", "error", err)
-			return c.String(500, "internal server error")
+			return internalError()
 		}
 		defer tx.Rollback(c.Request().Context())
 		queries := s.Queries.WithTx(tx)
@@ -62,38 +65,103 @@ func (s *Server) addPostHandler() echo.HandlerFunc {
 			Content:   req.Content,
 		})
 		if err != nil {
-			return c.JSON(400, echo.Map{"error": "unable to create post"})
+			return dbError(err, "create post")
 		}
 		if len(req.Tags) > 0 {
+			tags, err := s.resolveTagTitles(c.Request().Context(), req.Tags)
+			if err != nil {
+				slog.Error("resolve tags", "error", err)
+				return internalError()
+			}
 			err = queries.AddTagsToPost(c.Request().Context(), db.AddTagsToPostParams{
 				PostSlug: req.Slug,
-				Column2:  req.Tags,
+				Column2:  tags,
 			})
 			if err != nil {
-				slog.Error("add tags to post", "error", err)
-				return c.String(500, "internal server error")
+				return dbError(err, "add tags to post")
 			}
 		}
 		if err := tx.Commit(c.Request().Context()); err != nil {
 			slog.Error("commit transaction", "error", err)
-			return c.String(500, "internal server error")
+			return internalError()
 		}
 		return c.NoContent(http.StatusCreated)
 	})
 }
 
+// PATCH /api/v1/posts/:slug
+//
+// edits a post's content and/or (un)publishes it. fields that are missing are left alone.
+func (s *Server) updatePostHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Slug      string  `param:"slug"`
+		Content   *string `json:"content" required:"false"`   // new content
+		Published *bool   `json:"published" required:"false"` // publish (true) or unpublish (false)
+	}) error {
+		params := db.UpdatePostParams{Slug: req.Slug}
+		if req.Content != nil {
+			params.Content = pgText(*req.Content)
+		}
+		if req.Published != nil {
+			params.Published = pgtype.Bool{Bool: *req.Published, Valid: true}
+		}
+		post, err := s.Queries.UpdatePost(c.Request().Context(), params)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("post not found")
+		} else if err != nil {
+			slog.Error("update post", "error", err)
+			return internalError()
+		}
+		return c.JSON(http.StatusOK, post)
+	})
+}
+
+// DELETE /api/v1/posts/:slug
+//
+// the post is moved to the trash.
+func (s *Server) deletePostHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Slug string `param:"slug"`
+	}) error {
+		n, err := s.Queries.DeletePost(c.Request().Context(), req.Slug)
+		if err != nil {
+			slog.Error("delete post", "error", err)
+			return internalError("unable to delete post")
+		} else if n == 0 {
+			return notFound("post not found")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// PATCH /api/v1/posts/:slug/tag/:title
+//
+// adding a tag the post already has isn't an error. trashed posts and tags aren't found.
 func (s *Server) addTagToPostHandler() echo.HandlerFunc {
 	return handler(func(c echo.Context, req struct {
 		Slug  string `param:"slug"`
 		Title string `param:"title"`
 	}) error {
-		err := s.Queries.AddTagToPost(c.Request().Context(), db.AddTagToPostParams{
+		ctx := c.Request().Context()
+		n, err := s.Queries.AddTagToPost(ctx, db.AddTagToPostParams{
 			PostSlug: req.Slug,
 			TagTitle: req.Title,
 		})
 		if err != nil {
-			slog.Error("add tag to post", "error", err)
-			return c.String(404, "post or tag not found")
+			return dbError(err, "add tag to post")
+		} else if n == 0 { // already tagged, or the post or tag doesn't exist
+			if slugs, err := s.Queries.ListExistingPostSlugs(ctx, []string{req.Slug}); err != nil {
+				slog.Error("list existing posts", "error", err)
+				return internalError()
+			} else if len(slugs) == 0 {
+				return notFound("post not found")
+			}
+			if _, err := s.Queries.GetTag(ctx, req.Title); errors.Is(err, pgx.ErrNoRows) {
+				return notFound("tag not found")
+			} else if err != nil {
+				slog.Error("get tag", "error", err)
+				return internalError()
+			}
 		}
 		return c.NoContent(204)
 	})
@@ -110,7 +178,7 @@ func (s *Server) removeTagFromPostHandler() echo.HandlerFunc {
 		})
 		if err != nil {
 			slog.Error("remove tag from post", "error", err)
-			return c.String(404, "post or tag not found")
+			return internalError()
 		}
 		return c.NoContent(204)
 	})
@@ -1,101 +1,606 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/evanoberholster/imagemeta"
-	"github.com/evanoberholster/imagemeta/meta"
 	"github.com/labstack/echo/v4"
 	"github.com/tiredkangaroo/ajiteshcc/bucket"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
 )
 
+// UnescapeObjectNameMiddleware lets routes with a :name param be called with object keys that have
+// slashes (like the ones R2_PHOTOS_KEY_TEMPLATE makes), percent-encoded as %2F.
+func UnescapeObjectNameMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if i := slices.Index(c.ParamNames(), "name"); i != -1 {
+			values := c.ParamValues()
+			values[i] = unescapedParam(c, values[i])
+			c.SetParamValues(values...)
+		}
+		return next(c)
+	}
+}
+
 func (s *Server) listAllBucketPhotoObjects(c echo.Context) error {
-	objects, err := bucket.ListAllObjectsInBucket(c.Request().Context(), "photos")
+	objects, err := bucket.ListAllObjectsInBucket(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME)
 	if err != nil {
 		slog.Error("list all bucket photo objects", "error", err)
-		return c.JSON(500, map[string]string{"error": "unable to list objects"})
+		return internalError("unable to list objects")
 	}
 	return c.JSON(200, objects)
 }
 
+// POST /api/v1/objects
+//
+// originals larger than bucket.MultipartThreshold are uploaded to the bucket in parts. while they
+// are, their progress is listed by GET /api/v1/objects/:name/multipart (under the final key).
 func (s *Server) uploadPhotoToBucketHandler() echo.HandlerFunc {
 	return handler(func(c echo.Context, req struct {
-		Name string `form:"name"` // object key
+		Name      string `form:"name" required:"false"`      // object key (defaults to the uploaded file name), normalized and run through R2_PHOTOS_KEY_TEMPLATE
+		Collision string `form:"collision" required:"false"` // collision policy, overrides R2_PHOTOS_COLLISION_POLICY
+		Overwrite bool   `form:"overwrite"`                  // confirms overwriting an existing object (overwrite-with-confirm only)
 	}) error {
+		policy, err := collisionPolicy(req.Collision)
+		if err != nil {
+			return badRequest(err.Error())
+		}
 		fileheader, err := c.FormFile("file")
 		if err != nil {
 			slog.Error("get uploaded file", "error", err)
-			return c.JSON(400, map[string]string{"error": "file is required"})
+			return badRequest("file is required").WithField("file")
+		}
+		if fileheader.Size > env.DefaultEnv.MAX_UPLOAD_SIZE {
+			return newAPIError(413, "too_large", fmt.Sprintf("file is larger than %d bytes", env.DefaultEnv.MAX_UPLOAD_SIZE))
 		}
 		file, err := fileheader.Open()
 		if err != nil {
 			slog.Error("open uploaded file", "error", err)
-			return c.JSON(500, map[string]string{"error": "failed to open file"})
+			return internalError("failed to open file")
 		}
 		defer file.Close()
 
+		info, err := validateImage(file)
+		if err != nil {
+			return imageError(c, err)
+		}
+
 		md, err := metadata(file)
 		if err != nil {
 			slog.Error("extract metadata", "error", err)
-			return c.JSON(500, map[string]string{"error": "unable to extract metadata"})
+			return internalError("unable to extract metadata")
 		}
-
-		if seeker, ok := file.(io.ReadSeeker); ok {
-			_, err = seeker.Seek(0, io.SeekStart)
+		// an optional XMP sidecar (e.g. from Lightroom) overrides the embedded metadata
+		if sidecarheader, err := c.FormFile("sidecar"); err == nil {
+			sidecar, err := sidecarheader.Open()
+			if err != nil {
+				slog.Error("open uploaded sidecar", "error", err)
+				return internalError("failed to open sidecar")
+			}
+			err = mergeXMPSidecar(md, sidecar)
+			sidecar.Close()
 			if err != nil {
-				slog.Error("reset file reader", "error", err)
-				return c.JSON(500, map[string]string{"error": "internal server error"})
+				return badRequest("sidecar is not a valid XMP file").WithField("sidecar")
+			}
+		}
+		if err := rewind(file); err != nil {
+			slog.Error("reset file reader", "error", err)
+			return internalError()
+		}
+
+		// the content hash is only computed if the key template needs it
+		var hash string
+		if strings.Contains(env.DefaultEnv.R2_PHOTOS_KEY_TEMPLATE, "{hash}") {
+			if hash, err = contentHash(file); err != nil {
+				slog.Error("hash uploaded file", "error", err)
+				return internalError()
 			}
-		} else {
-			slog.Error("file is not seekable")
-			return c.JSON(500, map[string]string{"error": "internal server error"})
 		}
 
-		// warn: use of req.Name directly can lead to overwriting existing files and
-		// security issues. this is admin-only, but req.Name should still be handled.
-		if err := bucket.PutObjectInBucket(c.Request().Context(), "photos", req.Name, md, file); err != nil {
-			slog.Error("put object in bucket", "error", err)
-			return c.JSON(500, map[string]string{"error": "unable to upload file"})
+		name := req.Name
+		if name == "" {
+			name = fileheader.Filename
 		}
-		return c.JSON(200, map[string]string{"success": "file uploaded successfully"})
+		if path.Ext(name) == "" {
+			name += info.Format.Ext
+		}
+		key, err := bucket.ApplyKeyTemplate(env.DefaultEnv.R2_PHOTOS_KEY_TEMPLATE, name, hash, time.Now())
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		resolved, overwrite, err := bucket.ResolveObjectKey(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key, policy, req.Overwrite)
+		if err != nil {
+			return objectKeyError(c, err, key, policy)
+		}
+		key = resolved
+
+		if err := putPhotoObject(c.Request().Context(), key, md, objectHeaders(info.Format, key), file, fileheader.Size, overwrite); err != nil {
+			return objectKeyError(c, err, key, policy)
+		}
+		return c.JSON(200, map[string]string{"success": "file uploaded successfully", "name": key})
 	})
 }
 
+// contentHash returns the hex sha256 of file (used for the {hash} key template placeholder) and
+// rewinds it.
+func contentHash(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), rewind(file)
+}
+
+// putPhotoObject uploads a photo to the photos bucket. large originals (RAW files, panoramas) are
+// uploaded in parallel parts. unless overwrite is true, it fails with bucket.ErrObjectExists if the
+// key is taken.
+func putPhotoObject(ctx context.Context, key string, md map[string]string, headers bucket.ObjectHeaders, file interface {
+	io.Reader
+	io.ReaderAt
+}, size int64, overwrite bool) error {
+	bucketName := env.DefaultEnv.R2_PHOTOS_BUCKET_NAME
+	if size > bucket.MultipartThreshold {
+		progress := &uploadProgress{}
+		serverUploads.Store(key, progress)
+		defer serverUploads.CompareAndDelete(key, progress)
+		return bucket.PutLargeObjectInBucket(ctx, bucketName, key, md, headers, file, size, overwrite, func(uploadID string, uploaded, total int64) {
+			progress.set(uploadID, uploaded, total)
+			slog.Info("multipart upload progress", "name", key, "uploaded", uploaded, "total", total)
+		})
+	} else if overwrite {
+		return bucket.PutObjectInBucket(ctx, bucketName, key, md, headers, file)
+	}
+	return bucket.PutNewObjectInBucket(ctx, bucketName, key, md, headers, file)
+}
+
+// serverUploads has the progress of the multipart uploads putPhotoObject is running (for
+// POST /objects and imports), by object key. they're listed by the multipart endpoints like uploads
+// clients run themselves, with their progress.
+var serverUploads sync.Map // key -> *uploadProgress
+
+type uploadProgress struct {
+	mu       sync.Mutex
+	uploadID string
+	uploaded int64
+	total    int64
+}
+
+// set records the bytes uploaded so far. parts finish out of order, so it never goes backwards.
+func (p *uploadProgress) set(uploadID string, uploaded, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.uploadID, p.uploaded, p.total = uploadID, max(p.uploaded, uploaded), total
+}
+
+// serverUploadProgress returns the bytes uploaded and the total size of an upload the server is
+// running. ok is false for uploads clients run themselves (or that have finished).
+func serverUploadProgress(key, uploadID string) (uploaded, total int64, ok bool) {
+	v, ok := serverUploads.Load(key)
+	if !ok {
+		return 0, 0, false
+	}
+	p := v.(*uploadProgress)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.uploaded, p.total, p.uploadID == uploadID
+}
+
+// PATCH /api/v1/objects/:name
+//
+// replaces the object's metadata, along with the metadata of every photo using the object. like
+// setPhotoMetadata, the photos are only updated once the object has been.
 func (s *Server) updateObjectMetadataHandler() echo.HandlerFunc {
 	return handler(func(c echo.Context, req struct {
 		Metadata map[string]string `json:"metadata"` // new metadata
 	}) error {
+		ctx := c.Request().Context()
 		name := c.Param("name")
-		if err := bucket.UpdateObjectMetadata(c.Request().Context(), "photos", name, req.Metadata); err != nil {
+		md := canonicalMetadata(req.Metadata)
+		tx, err := s.Conn.Begin(ctx)
+		if err != nil {
+			slog.Error("begin transaction", "error", err)
+			return internalError()
+		}
+		defer tx.Rollback(ctx)
+		if _, err := s.Queries.WithTx(tx).UpdatePhotoMetadataByURL(ctx, db.UpdatePhotoMetadataByURLParams{
+			PhotoUrl: bucket.PublicURL(name),
+			Metadata: md,
+		}); err != nil {
+			slog.Error("update photo metadata by url", "error", err)
+			return internalError()
+		}
+		if err := bucket.UpdateObjectMetadata(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, name, md); err != nil {
 			slog.Error("update object metadata", "error", err)
-			return c.JSON(500, map[string]string{"error": "unable to update metadata"})
+			return internalError("unable to update metadata")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("commit transaction", "error", err)
+			return internalError("object metadata updated, but unable to update photos using it")
 		}
 		return c.JSON(200, map[string]string{"success": "metadata updated successfully"})
 	})
 }
 
-func metadata(file io.ReadSeeker) (map[string]string, error) {
-	exif, err := imagemeta.Decode(file)
+// DELETE /api/v1/objects/:name
+//
+// the object is moved to the trash. objects still referenced by a photo (trashed or not) can't be
+// deleted.
+func (s *Server) deleteObjectHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name string `param:"name"`
+	}) error {
+		ctx := c.Request().Context()
+		if bucket.IsTrashKey(req.Name) {
+			return notFound("object not found")
+		}
+		exists, err := bucket.ObjectExists(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name)
+		if err != nil {
+			slog.Error("check object exists", "error", err)
+			return internalError()
+		} else if !exists {
+			return notFound("object not found")
+		}
+		count, err := s.Queries.CountPhotosWithURL(ctx, bucket.PublicURL(req.Name))
+		if err != nil {
+			slog.Error("count photos with url", "error", err)
+			return internalError()
+		} else if count > 0 {
+			return conflict(fmt.Sprintf("object is used by %d photo(s)", count))
+		}
+		if err := bucket.TrashObject(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name); err != nil {
+			slog.Error("trash object", "error", err)
+			return internalError("unable to delete object")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// POST /api/v1/objects/:name/move
+//
+// moves (or renames) an object and points every photo using it at the new key. photos are updated
+// in a transaction that's only committed once the object has been copied, then the original is
+// deleted, so a failure part way through never leaves a photo pointing at a missing object. a
+// confirmed overwrite replaces the existing object only after the photo update has succeeded, and
+// is never undone by deleting the destination.
+func (s *Server) moveObjectHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name      string `param:"name"`
+		NewName   string `json:"new_name"`                   // new object key (normalized)
+		Collision string `json:"collision" required:"false"` // collision policy, overrides R2_PHOTOS_COLLISION_POLICY
+		Overwrite bool   `json:"overwrite"`                  // confirms overwriting an existing object (overwrite-with-confirm only)
+	}) error {
+		ctx := c.Request().Context()
+		if bucket.IsTrashKey(req.Name) {
+			return notFound("object not found")
+		}
+		policy, err := collisionPolicy(req.Collision)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		key, err := bucket.NormalizeKey(req.NewName)
+		if err != nil {
+			return badRequest(err.Error())
+		} else if key == req.Name {
+			return badRequest("new name is the same as the current name")
+		}
+		newKey, overwrite, err := bucket.ResolveObjectKey(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key, policy, req.Overwrite)
+		if err != nil {
+			return objectKeyError(c, err, key, policy)
+		}
+
+		tx, err := s.Conn.Begin(ctx)
+		if err != nil {
+			slog.Error("begin transaction", "error", err)
+			return internalError("unable to move object")
+		}
+		defer tx.Rollback(ctx)
+		updated, err := s.Queries.WithTx(tx).UpdatePhotoURL(ctx, db.UpdatePhotoURLParams{
+			OldUrl: bucket.PublicURL(req.Name),
+			NewUrl: bucket.PublicURL(newKey),
+		})
+		if err != nil {
+			slog.Error("update photo url", "error", err)
+			return internalError("unable to move object")
+		}
+
+		copyObject := bucket.CopyNewObject // fails if something was uploaded to newKey since it was resolved
+		if overwrite {
+			copyObject = bucket.CopyObject
+		}
+		if err := copyObject(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, newKey); errors.Is(err, bucket.ErrObjectNotFound) {
+			return notFound("object not found")
+		} else if errors.Is(err, bucket.ErrObjectExists) {
+			return objectKeyError(c, err, newKey, policy)
+		} else if err != nil {
+			slog.Error("copy object", "error", err)
+			return internalError("unable to move object")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("commit photo url update", "error", err)
+			if !overwrite {
+				// undo the copy so the move doesn't half-happen. an overwritten object can't be brought
+				// back, but photos still point at the original, which hasn't been deleted.
+				if err := bucket.DeleteObject(context.Background(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, newKey); err != nil {
+					slog.Error("delete copied object", "error", err)
+				}
+			}
+			return internalError("unable to move object")
+		}
+		if err := bucket.DeleteObject(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name); err != nil {
+			// photos already point at the new key, so the move has happened; only the original is left behind
+			slog.Error("delete moved object", "error", err)
+			return c.JSON(200, echo.Map{
+				"name":           newKey,
+				"photos_updated": updated,
+				"warning":        "unable to delete the original object",
+			})
+		}
+		return c.JSON(200, echo.Map{"name": newKey, "photos_updated": updated})
+	})
+}
+
+type multipartUpload struct {
+	Name          string `json:"name"`                     // object key
+	UploadID      string `json:"upload_id"`                // upload ID used to resume the upload
+	UploadedBytes int64  `json:"uploaded_bytes,omitempty"` // bytes uploaded so far, for uploads the server is running
+	TotalBytes    int64  `json:"total_bytes,omitempty"`    // size of the object, for uploads the server is running
+}
+
+// GET /api/v1/objects/:name/multipart
+//
+// lists the uploads in progress for an object: the ones clients run themselves, and the ones the
+// server is running for large originals sent to POST /objects (or imported), with their progress.
+func (s *Server) listMultipartUploadsHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name string `param:"name"`
+	}) error {
+		uploads, err := bucket.ListMultipartUploads(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name)
+		if err != nil {
+			slog.Error("list multipart uploads", "error", err)
+			return internalError("unable to list uploads")
+		}
+		statuses := []multipartUpload{}
+		for _, u := range uploads {
+			if u.Name != req.Name { // prefix matching also returns uploads for longer keys
+				continue
+			}
+			status := multipartUpload{Name: u.Name, UploadID: u.UploadID}
+			status.UploadedBytes, status.TotalBytes, _ = serverUploadProgress(u.Name, u.UploadID)
+			statuses = append(statuses, status)
+		}
+		return c.JSON(200, statuses)
+	})
+}
+
+// POST /api/v1/objects/:name/multipart
+//
+// the name is normalized and checked against the collision policy, so the returned name may differ
+// from the requested one and must be used for the rest of the upload. key templates aren't applied
+// since {hash} can't be known before the upload.
+func (s *Server) createMultipartUploadHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name      string `param:"name"`
+		Collision string `json:"collision" required:"false"` // collision policy, overrides R2_PHOTOS_COLLISION_POLICY
+		Overwrite bool   `json:"overwrite"`                  // confirms overwriting an existing object (overwrite-with-confirm only)
+	}) error {
+		policy, err := collisionPolicy(req.Collision)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		key, err := bucket.NormalizeKey(req.Name)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		resolved, _, err := bucket.ResolveObjectKey(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key, policy, req.Overwrite)
+		if err != nil {
+			return objectKeyError(c, err, key, policy)
+		}
+		// headers are set on completion, once the content can be sniffed
+		uploadID, err := bucket.CreateMultipartUpload(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, resolved, nil, bucket.ObjectHeaders{})
+		if err != nil {
+			slog.Error("create multipart upload", "error", err)
+			return internalError("unable to create upload")
+		}
+		return c.JSON(200, bucket.MultipartUpload{Name: resolved, UploadID: uploadID})
+	})
+}
+
+// GET /api/v1/objects/:name/multipart/:upload_id
+//
+// total_bytes is only known for uploads the server is running.
+func (s *Server) listUploadedPartsHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name     string `param:"name"`
+		UploadID string `param:"upload_id"`
+	}) error {
+		parts, err := bucket.ListParts(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID)
+		if err != nil {
+			slog.Error("list uploaded parts", "error", err)
+			return notFound("upload not found")
+		}
+		var uploaded int64
+		for _, p := range parts {
+			uploaded += p.Size
+		}
+		response := echo.Map{
+			"parts":          parts,
+			"part_count":     len(parts),
+			"uploaded_bytes": uploaded,
+		}
+		if _, total, ok := serverUploadProgress(req.Name, req.UploadID); ok {
+			response["total_bytes"] = total
+		}
+		return c.JSON(200, response)
+	})
+}
+
+// PUT /api/v1/objects/:name/multipart/:upload_id/:part
+//
+// the request body is the raw part. this doesn't go through handler since the body isn't
+// something echo can bind.
+func (s *Server) uploadPartHandler(c echo.Context) error {
+	name := c.Param("name")
+	uploadID := c.Param("upload_id")
+	partNumber, err := strconv.ParseInt(c.Param("part"), 10, 32)
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		return badRequest("part must be a number between 1 and 10000")
+	}
+	size := c.Request().ContentLength
+	if size <= 0 {
+		return badRequest("Content-Length is required")
+	} else if size > env.DefaultEnv.MAX_UPLOAD_SIZE {
+		return newAPIError(413, "too_large", fmt.Sprintf("part is larger than %d bytes", env.DefaultEnv.MAX_UPLOAD_SIZE))
+	}
+	part, err := bucket.UploadPart(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, name, uploadID, int32(partNumber), c.Request().Body, size)
 	if err != nil {
-		return nil, err
+		slog.Error("upload part", "error", err)
+		return internalError("unable to upload part")
 	}
-	return map[string]string{
-		"CreatedAt":    exif.CreateDate().String(),
-		"LensMake":     exif.LensMake,
-		"LensModel":    exif.LensModel,
-		"CameraMake":   exif.CameraMake.String(),
-		"CameraModel":  exif.CameraModel.String(),
-		"Aperture":     fmt.Sprintf("%f", exif.FNumber),
-		"FocalLength":  fmt.Sprintf("%f", exif.FocalLength),
-		"ISO":          fmt.Sprintf("%d", exif.ISOSpeed),
-		"ShutterSpeed": meta.ExposureTime(exif.ExposureTime).String(),
-		"ImageType":    exif.ImageType.String(),
-		"ImageWidth":   fmt.Sprintf("%d", exif.ImageWidth),
-		"ImageHeight":  fmt.Sprintf("%d", exif.ImageHeight),
-		"Latitude":     fmt.Sprintf("%f", exif.GPS.Latitude()),
-		"Longitude":    fmt.Sprintf("%f", exif.GPS.Longitude()),
-		"Altitude":     fmt.Sprintf("%f", exif.GPS.Altitude()),
-	}, nil
+	return c.JSON(200, part)
+}
+
+// POST /api/v1/objects/:name/multipart/:upload_id
+//
+// if an object was created at the name during the upload, the upload is kept (so it can be
+// completed again with overwrite) under overwrite-with-confirm, and aborted otherwise.
+func (s *Server) completeMultipartUploadHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name      string `param:"name"`
+		UploadID  string `param:"upload_id"`
+		Collision string `json:"collision" required:"false"` // collision policy the upload was created with, overrides R2_PHOTOS_COLLISION_POLICY
+		Overwrite bool   `json:"overwrite"`                  // confirms overwriting an object created during the upload (overwrite-with-confirm only)
+	}) error {
+		policy, err := collisionPolicy(req.Collision)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		overwrite := policy == bucket.CollisionOverwrite && req.Overwrite
+		ctx := c.Request().Context()
+		parts, err := bucket.ListParts(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID)
+		if err != nil {
+			slog.Error("list uploaded parts", "error", err)
+			return notFound("upload not found")
+		}
+		if len(parts) == 0 {
+			return badRequest("no parts have been uploaded")
+		}
+		var size int64
+		for _, p := range parts {
+			size += p.Size
+		}
+		if size > env.DefaultEnv.MAX_UPLOAD_SIZE {
+			if err := bucket.AbortMultipartUpload(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID); err != nil {
+				slog.Error("abort multipart upload", "error", err)
+			}
+			return newAPIError(413, "too_large", fmt.Sprintf("file is larger than %d bytes", env.DefaultEnv.MAX_UPLOAD_SIZE))
+		}
+		if err := bucket.CompleteMultipartUpload(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID, parts, overwrite); errors.Is(err, bucket.ErrObjectExists) && policy == bucket.CollisionOverwrite {
+			return conflict("object " + req.Name + " was created during the upload, complete it again with overwrite to replace it (or abort it)")
+		} else if errors.Is(err, bucket.ErrObjectExists) {
+			// the upload can never complete, so its parts are removed rather than left in the bucket
+			if err := bucket.AbortMultipartUpload(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID); err != nil {
+				slog.Error("abort multipart upload", "error", err)
+			}
+			return conflict("object " + req.Name + " was created during the upload, so the upload was aborted")
+		} else if err != nil {
+			return objectKeyError(c, err, req.Name, policy)
+		}
+
+		// the content can't be validated and metadata can't be extracted until the whole object
+		// exists, so both are done with the start of the object afterwards.
+		head, err := bucket.GetObjectRange(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, metadataReadSize)
+		if err != nil {
+			slog.Error("get object range", "error", err)
+			return internalError("file uploaded, but unable to validate it")
+		}
+		info, err := validateImage(bytes.NewReader(head))
+		if err != nil {
+			if err := bucket.DeleteObject(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name); err != nil {
+				slog.Error("delete invalid object", "error", err)
+			}
+			return imageError(c, err)
+		}
+		md, mdErr := metadata(bytes.NewReader(head))
+		if mdErr != nil {
+			slog.Error("extract metadata", "error", mdErr)
+		}
+		if err := bucket.ReplaceObjectMetadata(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, md, objectHeaders(info.Format, req.Name)); err != nil {
+			slog.Error("set multipart upload metadata", "error", err)
+			return internalError("file uploaded, but unable to set its metadata")
+		}
+		if mdErr != nil {
+			return c.JSON(200, map[string]string{
+				"success": "file uploaded successfully",
+				"warning": "unable to extract metadata",
+			})
+		}
+		return c.JSON(200, map[string]string{"success": "file uploaded successfully"})
+	})
+}
+
+// DELETE /api/v1/objects/:name/multipart/:upload_id
+func (s *Server) abortMultipartUploadHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name     string `param:"name"`
+		UploadID string `param:"upload_id"`
+	}) error {
+		if err := bucket.AbortMultipartUpload(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name, req.UploadID); err != nil {
+			slog.Error("abort multipart upload", "error", err)
+			return internalError("unable to abort upload")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// collisionPolicy returns the collision policy named by override, or R2_PHOTOS_COLLISION_POLICY if
+// override is empty.
+func collisionPolicy(override string) (bucket.CollisionPolicy, error) {
+	if override == "" {
+		override = env.DefaultEnv.R2_PHOTOS_COLLISION_POLICY
+	}
+	return bucket.ParseCollisionPolicy(override)
+}
+
+// objectKeyError responds to errors from resolving an object key and uploading to it.
+func objectKeyError(c echo.Context, err error, key string, policy bucket.CollisionPolicy) error {
+	switch {
+	case errors.Is(err, bucket.ErrObjectExists) && policy == bucket.CollisionOverwrite:
+		return conflict("object " + key + " already exists, set overwrite to replace it")
+	case errors.Is(err, bucket.ErrObjectExists):
+		return conflict("object " + key + " already exists")
+	default:
+		slog.Error("put object in bucket", "error", err)
+		return internalError("unable to upload file")
+	}
+}
+
+// imageError responds to errors from validateImage.
+func imageError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errUnsupportedImage), errors.Is(err, errInvalidImage):
+		return newAPIError(415, "unsupported_media_type", err.Error())
+	case errors.Is(err, errImageTooLarge):
+		return newAPIError(422, "unprocessable", err.Error())
+	default:
+		slog.Error("validate image", "error", err)
+		return internalError()
+	}
+}
+
+func rewind(file io.Seeker) error {
+	_, err := file.Seek(0, io.SeekStart)
+	return err
 }
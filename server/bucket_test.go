@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUnescapeObjectNameMiddleware(t *testing.T) {
+	e := echo.New()
+	var got string
+	h := func(c echo.Context) error {
+		got = c.Param("name")
+		return c.NoContent(204)
+	}
+	e.DELETE("/objects/:name", h, UnescapeObjectNameMiddleware)
+	e.POST("/objects/:name/move", h, UnescapeObjectNameMiddleware)
+	e.GET("/objects/:name/multipart/:upload_id", h, UnescapeObjectNameMiddleware)
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodDelete, "/objects/photo.jpg", "photo.jpg"},
+		{http.MethodDelete, "/objects/2024%2F05%2Fab12.jpg", "2024/05/ab12.jpg"},
+		{http.MethodPost, "/objects/2024%2F05%2Fab12.jpg/move", "2024/05/ab12.jpg"},
+		{http.MethodGet, "/objects/2024%2F05%2Fab12.jpg/multipart/upload", "2024/05/ab12.jpg"},
+		{http.MethodDelete, "/objects/my%20photo.jpg", "my photo.jpg"},
+		{http.MethodDelete, "/objects/a%2F100%25.jpg", "a/100%.jpg"},
+	}
+	for _, tt := range tests {
+		got = ""
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != 204 || got != tt.want {
+			t.Errorf("%s %s: got %d with name %q, want 204 with %q", tt.method, tt.path, rec.Code, got, tt.want)
+		}
+	}
+}
+
+func TestServerUploadProgress(t *testing.T) {
+	progress := &uploadProgress{}
+	serverUploads.Store("big.dng", progress)
+	defer serverUploads.Delete("big.dng")
+
+	progress.set("upload", 0, 300)
+	progress.set("upload", 200, 300)
+	progress.set("upload", 100, 300) // parts finish out of order
+	if uploaded, total, ok := serverUploadProgress("big.dng", "upload"); !ok || uploaded != 200 || total != 300 {
+		t.Errorf("got %d/%d (%v), want 200/300", uploaded, total, ok)
+	}
+	if _, _, ok := serverUploadProgress("big.dng", "other"); ok {
+		t.Error("got progress for an upload the server isn't running")
+	}
+	if _, _, ok := serverUploadProgress("small.jpg", "upload"); ok {
+		t.Error("got progress for an object the server isn't uploading")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgres error codes (https://www.postgresql.org/docs/current/errcodes-appendix.html)
+const (
+	pgNotNullViolation    = "23502"
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgCheckViolation      = "23514"
+)
+
+// constraintMessages has what clients are told when a request violates a constraint, by the
+// constraint's name (postgres's default names for the constraints in schema.sql). messages don't
+// mention tables or columns.
+var constraintMessages = map[string]string{
+	"posts_pkey":                "a post with this slug already exists",
+	"tags_pkey":                 "a tag with this title already exists",
+	"tags_slug_key":             "a tag with this slug already exists",
+	"tags_parent_title_fkey":    "parent tag not found",
+	"tags_check":                "a tag can't be its own parent",
+	"photo_tags_pkey":           "the photo already has this tag",
+	"photo_tags_photo_id_fkey":  "photo not found",
+	"photo_tags_tag_title_fkey": "tag not found",
+	"post_tags_pkey":            "the post already has this tag",
+	"post_tags_post_slug_fkey":  "post not found",
+	"post_tags_tag_title_fkey":  "tag not found",
+}
+
+// classifyDBError maps constraint violations to API errors: unique violations are 409s
+// (already_exists), foreign key violations 404s (not_found, since something referenced doesn't
+// exist) and not-null and check violations 422s (missing_value and invalid_value). the error's
+// field is the violated constraint (or the missing column). other errors aren't classified.
+func classifyDBError(err error) (*APIError, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil, false
+	}
+	message := func(fallback string) string {
+		if m, ok := constraintMessages[pgErr.ConstraintName]; ok {
+			return m
+		}
+		return fallback
+	}
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		return newAPIError(409, "already_exists", message("already exists")).WithField(pgErr.ConstraintName), true
+	case pgForeignKeyViolation:
+		return newAPIError(404, "not_found", message("something it refers to doesn't exist")).WithField(pgErr.ConstraintName), true
+	case pgNotNullViolation:
+		return newAPIError(422, "missing_value", fmt.Sprintf("missing value for %s", pgErr.ColumnName)).WithField(pgErr.ColumnName), true
+	case pgCheckViolation:
+		return newAPIError(422, "invalid_value", message("invalid value")).WithField(pgErr.ConstraintName), true
+	}
+	return nil, false
+}
+
+// isUniqueViolation reports whether err is a postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// dbError returns the classified error if err is a constraint violation, and logs it (as msg)
+// and returns an internal error otherwise.
+func dbError(err error, msg string) *APIError {
+	if e, ok := classifyDBError(err); ok {
+		return e
+	}
+	slog.Error(msg, "error", err)
+	return internalError()
+}
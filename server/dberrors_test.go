@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassifyDBError(t *testing.T) {
+	tests := []struct {
+		name                string
+		err                 error
+		status              int // 0 if the error isn't classified
+		code, field, substr string
+	}{
+		{
+			name:   "known unique constraint",
+			err:    &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "tags_slug_key", Detail: `Key (slug)=(kyoto) already exists.`},
+			status: 409, code: "already_exists", field: "tags_slug_key", substr: "a tag with this slug already exists",
+		},
+		{
+			name:   "known foreign key",
+			err:    &pgconn.PgError{Code: pgForeignKeyViolation, ConstraintName: "photo_tags_tag_title_fkey", Detail: `Key (tag_title)=(x) is not present in table "tags".`},
+			status: 404, code: "not_found", field: "photo_tags_tag_title_fkey", substr: "tag not found",
+		},
+		{
+			name:   "wrapped",
+			err:    fmt.Errorf("add photo tags: %w", &pgconn.PgError{Code: pgForeignKeyViolation, ConstraintName: "post_tags_post_slug_fkey"}),
+			status: 404, code: "not_found", field: "post_tags_post_slug_fkey", substr: "post not found",
+		},
+		{
+			name:   "unknown constraint",
+			err:    &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "widgets_pkey", Detail: `Key (id)=(1) already exists.`},
+			status: 409, code: "already_exists", field: "widgets_pkey", substr: "already exists",
+		},
+		{
+			name:   "check",
+			err:    &pgconn.PgError{Code: pgCheckViolation, ConstraintName: "tags_check"},
+			status: 422, code: "invalid_value", field: "tags_check", substr: "its own parent",
+		},
+		{
+			name:   "not null",
+			err:    &pgconn.PgError{Code: pgNotNullViolation, ColumnName: "content", TableName: "posts"},
+			status: 422, code: "missing_value", field: "content", substr: "content",
+		},
+		{name: "other postgres error", err: &pgconn.PgError{Code: "40001"}},
+		{name: "not a postgres error", err: errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := classifyDBError(tt.err)
+			if tt.status == 0 {
+				if ok {
+					t.Fatalf("got %v, want the error unclassified", e)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("error wasn't classified")
+			}
+			if e.Status != tt.status || e.Code != tt.code || e.Field != tt.field || !strings.Contains(e.Message, tt.substr) {
+				t.Errorf("got %v (field %q), want %d %s (field %q) containing %q", e, e.Field, tt.status, tt.code, tt.field, tt.substr)
+			}
+			for _, leak := range []string{"table", "Key (", "photo_tags", "post_tags", "posts", "widgets"} {
+				if strings.Contains(e.Message, leak) {
+					t.Errorf("message %q mentions %q", e.Message, leak)
+				}
+			}
+		})
+	}
+}
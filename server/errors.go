@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIError is the body of every error response:
+//
+//	{"code": "not_found", "message": "tag not found", "request_id": "..."}
+//
+// handlers return it and HTTPErrorHandler writes it.
+type APIError struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`                 // machine-readable, e.g. "not_found" or "already_exists"
+	Message   string      `json:"message"`              // human-readable
+	Field     string      `json:"field,omitempty"`      // the request field at fault, if any
+	RequestID string      `json:"request_id,omitempty"` // X-Request-Id of the request, for finding it in the logs
+	Errors    []*APIError `json:"errors,omitempty"`     // every invalid field, when there's more than one
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// WithField sets the request field the error is about.
+func (e *APIError) WithField(field string) *APIError {
+	e.Field = field
+	return e
+}
+
+func badRequest(message string) *APIError { return newAPIError(400, "bad_request", message) }
+func forbidden(message string) *APIError  { return newAPIError(403, "forbidden", message) }
+func notFound(message string) *APIError   { return newAPIError(404, "not_found", message) }
+func conflict(message string) *APIError   { return newAPIError(409, "conflict", message) }
+
+// internalError is returned after logging the underlying error. message defaults to "internal
+// server error".
+func internalError(message ...string) *APIError {
+	if len(message) > 0 {
+		return newAPIError(500, "internal", message[0])
+	}
+	return newAPIError(500, "internal", "internal server error")
+}
+
+// codes for echo's own errors (bad bodies, unknown routes...)
+var httpErrorCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	413: "too_large",
+	415: "unsupported_media_type",
+	429: "rate_limited",
+}
+
+// HTTPErrorHandler writes errors returned by handlers and middleware as APIErrors. echo's errors
+// keep their status; anything else is logged and becomes a 500.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+		apiErr = &APIError{Status: apiErr.Status, Code: apiErr.Code, Message: apiErr.Message, Field: apiErr.Field, Errors: apiErr.Errors}
+	case errors.As(err, &httpErr):
+		code, ok := httpErrorCodes[httpErr.Code]
+		if !ok {
+			code = "error"
+		}
+		apiErr = newAPIError(httpErr.Code, code, fmt.Sprint(httpErr.Message))
+		if httpErr.Code >= 500 {
+			apiErr.Code, apiErr.Message = "internal", http.StatusText(httpErr.Code)
+			slog.Error("unhandled error", "path", c.Path(), "error", err)
+		}
+	default:
+		slog.Error("unhandled error", "path", c.Path(), "error", err)
+		apiErr = internalError()
+	}
+	apiErr.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, apiErr)
+	}
+	if err != nil {
+		slog.Error("write error response", "error", err)
+	}
+}
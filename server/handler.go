@@ -1,47 +1,341 @@
 package server
 
 import (
+	"fmt"
+	"net/url"
 	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 )
 
+// handler binds requests into T and validates them before calling fn. fields are validated by
+// their tags:
+//
+//	required:"false"        pointers, slices, maps, strings, interfaces and arrays are required unless this is set
+//	required:"true"         numbers must be non-zero (optional by default); not allowed on bools and structs
+//	minlen:"n" maxlen:"n"   length of strings (in characters), slices and maps
+//	min:"n" max:"n"         range of numbers
+//	pattern:"regexp"        strings must match the regular expression (patterns aren't anchored; use ^…$)
+//	enum:"a,b,c"            strings (or every string in a slice) must be one of the values
+//	format:"slug"           strings must be slugs (lowercase letters and digits joined by single dashes)
+//
+// zero values are "not given" and only checked by required. nested structs (and pointers to and
+// slices of them) declared in this package are validated too; non-pointer structs always are. all
+// invalid fields are reported at once. the rules are worked out once, when the handler is created;
+// bad tags panic.
 func handler[T any](fn func(c echo.Context, req T) error) echo.HandlerFunc {
-	rt := reflect.TypeFor[T]()
-	var requiredFields []int
-	for i := range rt.NumField() {
-		field := rt.Field(i)
-		switch field.Type.Kind() {
-		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.String, reflect.Interface, reflect.Array:
-			// these can be nil/zero, so we check for 'required' tag
-		default:
-			continue // skip other types
-		}
-		requiredStr := field.Tag.Get("required")
-		if requiredStr == "false" {
-			continue
-		}
-		requiredFields = append(requiredFields, i)
+	rules := validationRules(reflect.TypeFor[T]())
+	name := "handler"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		name = operationName(runtime.FuncForPC(pc).Name())
 	}
 	return func(c echo.Context) error {
+		if d, ok := c.Get(describeHandlerKey).(*handlerDescription); ok { // see describe (openapi.go)
+			d.name, d.request = name, reflect.TypeFor[T]()
+			return nil
+		}
 		var req T
 		if err := c.Bind(&req); err != nil {
 			return err
 		}
-		rv := reflect.ValueOf(req)
-		for _, fieldNumber := range requiredFields {
-			if rv.Field(fieldNumber).IsZero() {
-				return c.String(400, "bad request: missing required field "+rt.Field(fieldNumber).Name)
-			}
+		if err := validate(reflect.ValueOf(req), rules); err != nil {
+			return err
 		}
 		return fn(c, req)
 	}
 }
 
+// unescapedParam decodes a path param echo left escaped. echo routes on RawPath when the path has
+// escapes that decoding would lose (e.g. %2F), and params are left escaped then; otherwise they're
+// already decoded.
+func unescapedParam(c echo.Context, value string) string {
+	if c.Request().URL.RawPath == "" {
+		return value
+	}
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+// request structs declared in this package are validated when nested; other structs (pgtype.Text,
+// time.Time...) aren't
+var serverPkgPath = reflect.TypeFor[APIError]().PkgPath()
+
+// fieldRule validates one field of a request struct.
+type fieldRule struct {
+	index    int
+	name     string // as the client sends it
+	required bool
+	checks   []func(v reflect.Value, name string) *APIError // v is never a pointer; only called for non-zero values
+	nested   []fieldRule                                    // rules of a nested struct (or of a slice's structs)
+}
+
+func validationRules(rt reflect.Type) []fieldRule {
+	var rules []fieldRule
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		rule := fieldRule{index: i, name: fieldName(field)}
+		rule.required = fieldRequired(field)
+		rule.checks = fieldChecks(field)
+
+		elem := field.Type
+		for elem.Kind() == reflect.Pointer || elem.Kind() == reflect.Slice {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct && (elem.Name() == "" || elem.PkgPath() == serverPkgPath) {
+			rule.nested = validationRules(elem)
+		}
+		if rule.required || len(rule.checks) > 0 || len(rule.nested) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// fieldRequired reports whether a field must be set. fields that can be nil or empty are
+// required unless they opt out; numbers only opt in, since 0 is often a real value. a required
+// bool or struct can't be told apart from one that wasn't sent, so that panics (use a pointer).
+func fieldRequired(field reflect.StructField) bool {
+	tag, ok := field.Tag.Lookup("required")
+	if ok && tag != "true" && tag != "false" {
+		panic(fmt.Sprintf("field %s: invalid required tag %q", field.Name, tag))
+	}
+	switch kind := field.Type.Kind(); kind {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.String, reflect.Interface, reflect.Array:
+		return tag != "false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return tag == "true" // 0 is missing
+	default:
+		if tag == "true" {
+			panic(fmt.Sprintf("field %s: required has no effect on a %s, use a pointer", field.Name, kind))
+		}
+		return false
+	}
+}
+
+func fieldChecks(field reflect.StructField) []func(reflect.Value, string) *APIError {
+	var checks []func(reflect.Value, string) *APIError
+	tagInt := func(tag string) (int, bool) {
+		s, ok := field.Tag.Lookup(tag)
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			panic(fmt.Sprintf("field %s: invalid %s tag %q", field.Name, tag, s))
+		}
+		return n, true
+	}
+	tagFloat := func(tag string) (float64, bool) {
+		s, ok := field.Tag.Lookup(tag)
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			panic(fmt.Sprintf("field %s: invalid %s tag %q", field.Name, tag, s))
+		}
+		return n, true
+	}
+
+	if n, ok := tagInt("minlen"); ok {
+		checks = append(checks, func(v reflect.Value, name string) *APIError {
+			if l, ok := length(v); ok && l < n {
+				return fieldError("too_short", name, fmt.Sprintf("%s must be at least %d long", name, n))
+			}
+			return nil
+		})
+	}
+	if n, ok := tagInt("maxlen"); ok {
+		checks = append(checks, func(v reflect.Value, name string) *APIError {
+			if l, ok := length(v); ok && l > n {
+				return fieldError("too_long", name, fmt.Sprintf("%s must be at most %d long", name, n))
+			}
+			return nil
+		})
+	}
+	if n, ok := tagFloat("min"); ok {
+		checks = append(checks, func(v reflect.Value, name string) *APIError {
+			if f, ok := number(v); ok && f < n {
+				return fieldError("out_of_range", name, fmt.Sprintf("%s must be at least %v", name, n))
+			}
+			return nil
+		})
+	}
+	if n, ok := tagFloat("max"); ok {
+		checks = append(checks, func(v reflect.Value, name string) *APIError {
+			if f, ok := number(v); ok && f > n {
+				return fieldError("out_of_range", name, fmt.Sprintf("%s must be at most %v", name, n))
+			}
+			return nil
+		})
+	}
+	if pattern, ok := field.Tag.Lookup("pattern"); ok {
+		re := regexp.MustCompile(pattern)
+		checks = append(checks, stringCheck(func(s, name string) *APIError {
+			if !re.MatchString(s) {
+				return fieldError("invalid_format", name, fmt.Sprintf("%s must match %s", name, pattern))
+			}
+			return nil
+		}))
+	}
+	if enum, ok := field.Tag.Lookup("enum"); ok {
+		values := strings.Split(enum, ",")
+		checks = append(checks, stringCheck(func(s, name string) *APIError {
+			if !slices.Contains(values, s) {
+				return fieldError("invalid_value", name, fmt.Sprintf("%s must be one of %s", name, strings.Join(values, ", ")))
+			}
+			return nil
+		}))
+	}
+	switch format := field.Tag.Get("format"); format {
+	case "":
+	case "slug":
+		checks = append(checks, stringCheck(func(s, name string) *APIError {
+			if !tagSlugRegexp.MatchString(s) {
+				return fieldError("invalid_format", name, name+" may only contain lowercase letters, digits and single dashes")
+			}
+			return nil
+		}))
+	default:
+		panic(fmt.Sprintf("field %s: unknown format %q", field.Name, format))
+	}
+	return checks
+}
+
+// stringCheck applies check to a string, or to every string in a slice.
+func stringCheck(check func(s, name string) *APIError) func(reflect.Value, string) *APIError {
+	return func(v reflect.Value, name string) *APIError {
+		switch {
+		case v.Kind() == reflect.String:
+			return check(v.String(), name)
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+			for i := range v.Len() {
+				if err := check(v.Index(i).String(), fmt.Sprintf("%s[%d]", name, i)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func length(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch {
+	case v.CanInt():
+		return float64(v.Int()), true
+	case v.CanUint():
+		return float64(v.Uint()), true
+	case v.CanFloat():
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// validate checks v against rules, returning every invalid field in one error.
+func validate(v reflect.Value, rules []fieldRule) error {
+	errs := validateFields(v, rules, "", nil)
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	e := newAPIError(400, "invalid_fields", fmt.Sprintf("%d fields are invalid", len(errs)))
+	e.Errors = errs
+	return e
+}
+
+// validateFields appends the errors of v's fields to errs. prefix is the name of v's field in the
+// enclosing struct (e.g. "items[0].") for nested structs.
+func validateFields(v reflect.Value, rules []fieldRule, prefix string, errs []*APIError) []*APIError {
+	for _, rule := range rules {
+		name := prefix + rule.name
+		field := v.Field(rule.index)
+		if field.IsZero() {
+			if rule.required {
+				errs = append(errs, fieldError("missing_field", name, "missing required field "+name))
+			} else if field.Kind() == reflect.Struct && len(rule.nested) > 0 {
+				errs = validateFields(field, rule.nested, name+".", errs) // optional structs are pointers
+			}
+			continue
+		}
+		for field.Kind() == reflect.Pointer {
+			if field = field.Elem(); field.IsZero() {
+				break
+			}
+		}
+		if field.IsZero() {
+			if field.Kind() == reflect.Struct && len(rule.nested) > 0 {
+				errs = validateFields(field, rule.nested, name+".", errs) // e.g. {} for a pointer to a struct
+			}
+			continue
+		}
+		for _, check := range rule.checks {
+			if err := check(field, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		switch {
+		case len(rule.nested) == 0:
+		case field.Kind() == reflect.Struct:
+			errs = validateFields(field, rule.nested, name+".", errs)
+		case field.Kind() == reflect.Slice:
+			for i := range field.Len() {
+				item := field.Index(i)
+				for item.Kind() == reflect.Pointer && !item.IsNil() {
+					item = item.Elem()
+				}
+				if item.Kind() == reflect.Struct {
+					errs = validateFields(item, rule.nested, fmt.Sprintf("%s[%d].", name, i), errs)
+				}
+			}
+		}
+	}
+	return errs
+}
+
 func pgText(s string) pgtype.Text {
 	return pgtype.Text{
 		String: s,
 		Valid:  true,
 	}
 }
+
+// fieldName is the name of a request field as the client sends it (its json, query, param or form
+// name).
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "param", "form"} {
+		if v, _, _ := strings.Cut(field.Tag.Get(tag), ","); v != "" && v != "-" {
+			return v
+		}
+	}
+	return field.Name
+}
+
+func fieldError(code, field, message string) *APIError {
+	return newAPIError(400, code, message).WithField(field)
+}
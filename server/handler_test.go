@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type testItem struct {
+	ID string `json:"id" format:"slug"`
+}
+
+type testRequest struct {
+	Name   string     `json:"name" minlen:"2" maxlen:"5"`
+	Count  int        `json:"count" required:"true" min:"1" max:"10"`
+	Offset int        `json:"offset" min:"0"`
+	Flag   *bool      `json:"flag"`
+	Note   string     `json:"note" required:"false" pattern:"^[a-z]+$"`
+	Kind   string     `json:"kind" required:"false" enum:"a,b"`
+	Tags   []string   `json:"tags" required:"false" maxlen:"2" format:"slug"`
+	Item   *testItem  `json:"item" required:"false"`
+	Items  []testItem `json:"items" required:"false"`
+}
+
+// validationErrors returns the "code field" of every error validate reports for req.
+func validationErrors(t *testing.T, req testRequest) []string {
+	t.Helper()
+	err := validate(reflect.ValueOf(req), validationRules(reflect.TypeFor[testRequest]()))
+	if err == nil {
+		return nil
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 400 {
+		t.Fatalf("validate returned %v, want a 400 *APIError", err)
+	}
+	errs := apiErr.Errors
+	if len(errs) == 0 {
+		errs = []*APIError{apiErr}
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Code+" "+e.Field)
+	}
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	no := false
+	valid := testRequest{Name: "ab", Count: 1, Flag: &no}
+	tests := []struct {
+		name string
+		edit func(r *testRequest)
+		want []string
+	}{
+		{"valid", func(r *testRequest) {}, nil},
+		{"a false bool pointer is set", func(r *testRequest) { r.Flag = &no }, nil},
+		{"missing string", func(r *testRequest) { r.Name = "" }, []string{"missing_field name"}},
+		{"missing pointer", func(r *testRequest) { r.Flag = nil }, []string{"missing_field flag"}},
+		{"required number is zero", func(r *testRequest) { r.Count = 0 }, []string{"missing_field count"}},
+		{"optional number is zero", func(r *testRequest) { r.Offset = 0 }, nil},
+		{"too short", func(r *testRequest) { r.Name = "a" }, []string{"too_short name"}},
+		{"too long in characters", func(r *testRequest) { r.Name = "éééééé" }, []string{"too_long name"}},
+		{"long in bytes only", func(r *testRequest) { r.Name = "ééééé" }, nil},
+		{"above max", func(r *testRequest) { r.Count = 11 }, []string{"out_of_range count"}},
+		{"below min", func(r *testRequest) { r.Offset = -1 }, []string{"out_of_range offset"}},
+		{"pattern", func(r *testRequest) { r.Note = "Abc" }, []string{"invalid_format note"}},
+		{"enum", func(r *testRequest) { r.Kind = "c" }, []string{"invalid_value kind"}},
+		{"slice length", func(r *testRequest) { r.Tags = []string{"a", "b", "c"} }, []string{"too_long tags"}},
+		{"every string in a slice", func(r *testRequest) { r.Tags = []string{"ok", "Not OK"} }, []string{"invalid_format tags[1]"}},
+		{"nested pointer", func(r *testRequest) { r.Item = &testItem{} }, []string{"missing_field item.id"}},
+		{"nested slice", func(r *testRequest) { r.Items = []testItem{{ID: "a"}, {ID: "a--b"}} }, []string{"invalid_format items[1].id"}},
+		{"every invalid field", func(r *testRequest) { r.Name, r.Count, r.Kind = "", 11, "c" }, []string{
+			"missing_field name", "out_of_range count", "invalid_value kind",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.edit(&req)
+			if got := validationErrors(t, req); !slices.Equal(got, tt.want) {
+				t.Errorf("got errors %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationRulesPanic(t *testing.T) {
+	tests := []struct {
+		name string
+		t    reflect.Type
+	}{
+		{"required bool", reflect.TypeFor[struct {
+			B bool `json:"b" required:"true"`
+		}]()},
+		{"required struct", reflect.TypeFor[struct {
+			S testItem `json:"s" required:"true"`
+		}]()},
+		{"invalid required", reflect.TypeFor[struct {
+			S string `json:"s" required:"yes"`
+		}]()},
+		{"invalid min", reflect.TypeFor[struct {
+			N int `json:"n" min:"one"`
+		}]()},
+		{"unknown format", reflect.TypeFor[struct {
+			S string `json:"s" format:"email"`
+		}]()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("validationRules didn't panic")
+				}
+			}()
+			validationRules(tt.t)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // registers the JPEG config decoder
+	_ "image/png"  // registers the PNG config decoder
+	"io"
+	"mime"
+	"path"
+
+	"github.com/tiredkangaroo/ajiteshcc/bucket"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"golang.org/x/image/webp"
+)
+
+// imageFormat is an image format accepted for upload.
+type imageFormat struct {
+	ContentType string // MIME type the object is served with
+	Ext         string // canonical extension, added to upload names that have none
+}
+
+var (
+	formatJPEG = imageFormat{ContentType: "image/jpeg", Ext: ".jpg"}
+	formatPNG  = imageFormat{ContentType: "image/png", Ext: ".png"}
+	formatWebP = imageFormat{ContentType: "image/webp", Ext: ".webp"}
+	formatHEIC = imageFormat{ContentType: "image/heic", Ext: ".heic"}
+	formatAVIF = imageFormat{ContentType: "image/avif", Ext: ".avif"}
+	formatTIFF = imageFormat{ContentType: "image/tiff", Ext: ".tiff"} // also DNG and most camera RAW files
+)
+
+// errors
+var (
+	errUnsupportedImage = errors.New("unsupported image format (expected JPEG, PNG, WebP, HEIC, AVIF or TIFF-based RAW)")
+	errInvalidImage     = errors.New("invalid image")
+	errImageTooLarge    = errors.New("image dimensions are too large")
+)
+
+// number of bytes needed to sniff every supported format
+const sniffLength = 512
+
+type imageInfo struct {
+	Format imageFormat
+	Width  int
+	Height int
+}
+
+// validateImage detects the format of an image from its content (not its name) and checks its
+// dimensions against MAX_IMAGE_DIMENSION and MAX_IMAGE_PIXELS. only headers are read; the image is
+// never decoded. file is rewound before returning.
+func validateImage(file io.ReadSeeker) (imageInfo, error) {
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return imageInfo{}, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if err := rewind(file); err != nil {
+		return imageInfo{}, err
+	}
+	format, err := sniffImageFormat(header[:n])
+	if err != nil {
+		return imageInfo{}, err
+	}
+	width, height, err := imageDimensions(file, format)
+	if err != nil {
+		return imageInfo{}, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if err := rewind(file); err != nil {
+		return imageInfo{}, err
+	}
+
+	maxDim, maxPixels := env.DefaultEnv.MAX_IMAGE_DIMENSION, env.DefaultEnv.MAX_IMAGE_PIXELS
+	if int64(width) > maxDim || int64(height) > maxDim {
+		return imageInfo{}, fmt.Errorf("%w: %dx%d exceeds %d pixels per side", errImageTooLarge, width, height, maxDim)
+	} else if int64(width)*int64(height) > maxPixels {
+		return imageInfo{}, fmt.Errorf("%w: %dx%d exceeds %d pixels", errImageTooLarge, width, height, maxPixels)
+	}
+	return imageInfo{Format: format, Width: width, Height: height}, nil
+}
+
+// sniffImageFormat detects the image format from the first bytes of a file.
+func sniffImageFormat(header []byte) (imageFormat, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return formatJPEG, nil
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return formatPNG, nil
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return formatWebP, nil
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return formatTIFF, nil
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return sniffFtypFormat(header)
+	}
+	return imageFormat{}, errUnsupportedImage
+}
+
+// sniffFtypFormat tells HEIC and AVIF apart using the brands in the ISOBMFF 'ftyp' box.
+func sniffFtypFormat(header []byte) (imageFormat, error) {
+	size := int(binary.BigEndian.Uint32(header[0:4]))
+	if size < 16 || size > len(header) {
+		size = len(header)
+	}
+	// major brand at 8, minor version at 12, compatible brands from 16
+	brands := []string{string(header[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(header[i:i+4]))
+	}
+	isHEIC := false
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return formatAVIF, nil
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			isHEIC = true
+		}
+	}
+	if isHEIC {
+		return formatHEIC, nil
+	}
+	return imageFormat{}, errUnsupportedImage
+}
+
+// imageDimensions reads the width and height of an image from its header.
+func imageDimensions(r io.ReadSeeker, format imageFormat) (int, int, error) {
+	switch format {
+	case formatJPEG, formatPNG:
+		cfg, _, err := image.DecodeConfig(r)
+		return cfg.Width, cfg.Height, err
+	case formatWebP:
+		cfg, err := webp.DecodeConfig(r)
+		return cfg.Width, cfg.Height, err
+	case formatTIFF:
+		return tiffDimensions(r)
+	case formatHEIC, formatAVIF:
+		data, err := io.ReadAll(io.LimitReader(r, metadataReadSize))
+		if err != nil {
+			return 0, 0, err
+		}
+		return heifDimensions(data)
+	}
+	return 0, 0, errUnsupportedImage
+}
+
+// heifDimensions returns the largest image spatial extents ('ispe') property in a HEIF (HEIC/AVIF)
+// file. the largest one is the full image; the others are tiles and thumbnails.
+func heifDimensions(data []byte) (int, int, error) {
+	var width, height uint32
+	var walk func(b []byte)
+	walk = func(b []byte) {
+		for len(b) >= 8 {
+			size, headerSize := uint64(binary.BigEndian.Uint32(b[0:4])), uint64(8)
+			boxType := string(b[4:8])
+			if size == 1 { // 64-bit size
+				if len(b) < 16 {
+					return
+				}
+				size, headerSize = binary.BigEndian.Uint64(b[8:16]), 16
+			} else if size == 0 { // box extends to the end
+				size = uint64(len(b))
+			}
+			if size < headerSize || size > uint64(len(b)) {
+				return
+			}
+			body := b[headerSize:size]
+			switch boxType {
+			case "meta": // full box: skip version and flags
+				if len(body) >= 4 {
+					walk(body[4:])
+				}
+			case "iprp", "ipco":
+				walk(body)
+			case "ispe": // full box: version and flags, width, height
+				if len(body) >= 12 {
+					w, h := binary.BigEndian.Uint32(body[4:8]), binary.BigEndian.Uint32(body[8:12])
+					if uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+						width, height = w, h
+					}
+				}
+			}
+			b = b[size:]
+		}
+	}
+	walk(data)
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("no image dimensions found")
+	}
+	return int(width), int(height), nil
+}
+
+// maximum number of IFDs tiffDimensions reads, so crafted files with huge or looping IFD chains
+// can't keep it busy
+const maxTIFFIFDs = 64
+
+// tiffDimensions returns the largest width and height of any image in a TIFF file, following the
+// IFD chain and SubIFDs. camera RAW files are TIFF-like but often not readable as plain TIFF, and
+// keep the full image behind a preview (in a SubIFD or a later IFD), so the first IFD isn't enough.
+func tiffDimensions(r io.ReadSeeker) (int, int, error) {
+	header, err := readAt(r, 0, 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	var width, height uint32
+	seen := make(map[uint32]bool)
+	queue := []uint32{order.Uint32(header[4:8])}
+	for len(queue) > 0 && len(seen) < maxTIFFIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] {
+			continue
+		}
+		seen[offset] = true
+		b, err := readAt(r, int64(offset), 2)
+		if err != nil {
+			continue // IFDs past the end (e.g. of a partial read) are skipped
+		}
+		count := int(order.Uint16(b))
+		entries, err := readAt(r, int64(offset)+2, count*12+4)
+		if err != nil {
+			continue
+		}
+		var w, h uint32
+		for i := range count {
+			e := entries[i*12 : i*12+12]
+			tag, typ, n := order.Uint16(e[0:2]), order.Uint16(e[2:4]), order.Uint32(e[4:8])
+			value := order.Uint32(e[8:12])
+			if typ == 3 { // SHORT
+				value = uint32(order.Uint16(e[8:10]))
+			}
+			switch tag {
+			case 0x0100: // ImageWidth
+				w = value
+			case 0x0101: // ImageLength
+				h = value
+			case 0x014A: // SubIFDs: the offset itself if there's one, otherwise where the offsets are
+				if n == 1 {
+					queue = append(queue, value)
+				} else if offsets, err := readAt(r, int64(value), int(min(n, maxTIFFIFDs))*4); err == nil {
+					for j := 0; j < len(offsets); j += 4 {
+						queue = append(queue, order.Uint32(offsets[j:]))
+					}
+				}
+			}
+		}
+		if uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+			width, height = w, h
+		}
+		queue = append(queue, order.Uint32(entries[count*12:])) // next IFD
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("no image dimensions found")
+	}
+	return int(width), int(height), nil
+}
+
+// readAt reads n bytes at offset.
+func readAt(r io.ReadSeeker, offset int64, n int) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// objectHeaders returns the headers an uploaded photo is served with.
+func objectHeaders(format imageFormat, key string) bucket.ObjectHeaders {
+	return bucket.ObjectHeaders{
+		ContentType:        format.ContentType,
+		CacheControl:       env.DefaultEnv.R2_PHOTOS_CACHE_CONTROL,
+		ContentDisposition: mime.FormatMediaType("inline", map[string]string{"filename": path.Base(key)}),
+	}
+}
@@ -0,0 +1,464 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/bucket"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// import sources
+const (
+	ImportFromBucket = "bucket" // every object under a key prefix in the photos bucket
+	ImportFromLocal  = "local"  // every file in a directory on the machine running the import
+)
+
+// import file statuses (see import_files.status)
+const (
+	importFileImported = "imported"
+	importFileSkipped  = "skipped"
+	importFileFailed   = "failed"
+)
+
+// errors
+var (
+	ErrImportRunning       = errors.New("import is already running")
+	errUnknownImportSource = errors.New("unknown import source (expected bucket or local)")
+	errAlreadyPhoto        = errors.New("already used by a photo")
+)
+
+// POST /api/v1/imports
+//
+// starts a bulk import in a background job. progress and per-file results are at
+// GET /api/v1/imports/:id.
+func (s *Server) createImportHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Source      string   `json:"source" enum:"bucket,local"`
+		Path        string   `json:"path" required:"false"` // bucket key prefix or local directory
+		Tags        []string `json:"tags" required:"false"` // tags added to every imported photo
+		Concurrency int      `json:"concurrency" max:"64"`  // files imported at once (defaults to IMPORT_CONCURRENCY)
+	}) error {
+		imp, err := s.CreateImport(c.Request().Context(), req.Source, req.Path, req.Tags)
+		if err != nil {
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) || errors.Is(err, errUnknownImportSource) {
+				return badRequest(err.Error())
+			}
+			slog.Error("create import", "error", err)
+			return internalError("unable to create import")
+		}
+		if _, err := s.enqueueJob(c.Request().Context(), s.Queries, jobRunImport, runImportJob{
+			ImportID:    imp.ID,
+			Concurrency: req.Concurrency,
+		}); err != nil {
+			slog.Error("enqueue import job", "error", err)
+			return internalError("import created, but unable to start it")
+		}
+		return c.JSON(202, imp)
+	})
+}
+
+// GET /api/v1/imports
+func (s *Server) listImports(c echo.Context) error {
+	imports, err := s.Queries.ListImports(c.Request().Context())
+	if err != nil {
+		slog.Error("list imports", "error", err)
+		return internalError()
+	}
+	return c.JSON(200, imports)
+}
+
+// GET /api/v1/imports/:id
+func (s *Server) getImportHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID int32 `param:"id"`
+	}) error {
+		ctx := c.Request().Context()
+		imp, err := s.Queries.GetImport(ctx, req.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("import not found")
+		} else if err != nil {
+			slog.Error("get import", "error", err)
+			return internalError()
+		}
+		counts, err := s.Queries.CountImportFilesByStatus(ctx, req.ID)
+		if err != nil {
+			slog.Error("count import files", "error", err)
+			return internalError()
+		}
+		files, err := s.Queries.ListImportFiles(ctx, req.ID)
+		if err != nil {
+			slog.Error("list import files", "error", err)
+			return internalError()
+		}
+		progress := map[string]int64{"pending": 0, importFileImported: 0, importFileSkipped: 0, importFileFailed: 0}
+		for _, count := range counts {
+			progress[count.Status] = count.Count
+		}
+		return c.JSON(200, echo.Map{
+			"import":   imp,
+			"progress": progress,
+			"files":    files,
+		})
+	})
+}
+
+// POST /api/v1/imports/:id/resume
+//
+// continues an interrupted import and retries the files that failed.
+func (s *Server) resumeImportHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID          int32 `param:"id"`
+		Concurrency int   `json:"concurrency" max:"64"` // files imported at once (defaults to IMPORT_CONCURRENCY)
+	}) error {
+		ctx := c.Request().Context()
+		imp, err := s.Queries.GetImport(ctx, req.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("import not found")
+		} else if err != nil {
+			slog.Error("get import", "error", err)
+			return internalError()
+		} else if imp.Status == "running" {
+			return conflict(ErrImportRunning.Error())
+		}
+		if _, err := s.enqueueJob(ctx, s.Queries, jobRunImport, runImportJob{
+			ImportID:    req.ID,
+			Concurrency: req.Concurrency,
+		}); err != nil {
+			slog.Error("enqueue import job", "error", err)
+			return internalError("unable to resume import")
+		}
+		return c.JSON(202, map[string]string{"success": "import resumed"})
+	})
+}
+
+// CreateImport lists the files to import from a bucket prefix or local directory and records
+// them, so the import can be resumed if it's interrupted. .xmp sidecars are not imported on their
+// own; they're applied to the photo they belong to.
+func (s *Server) CreateImport(ctx context.Context, source, dir string, tags []string) (db.Import, error) {
+	var names []string
+	var err error
+	switch source {
+	case ImportFromBucket:
+		names, err = bucket.ListObjectKeys(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, dir)
+	case ImportFromLocal:
+		names, err = listLocalImportFiles(dir)
+	default:
+		return db.Import{}, fmt.Errorf("%w: %q", errUnknownImportSource, source)
+	}
+	if err != nil {
+		return db.Import{}, err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	tx, err := s.Conn.Begin(ctx)
+	if err != nil {
+		return db.Import{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	queries := s.Queries.WithTx(tx)
+	imp, err := queries.CreateImport(ctx, db.CreateImportParams{
+		Source: source,
+		Path:   dir,
+		Tags:   tags,
+	})
+	if err != nil {
+		return db.Import{}, fmt.Errorf("create import: %w", err)
+	}
+	if err := queries.AddImportFiles(ctx, db.AddImportFilesParams{
+		ImportID: imp.ID,
+		Column2:  names,
+	}); err != nil {
+		return db.Import{}, fmt.Errorf("add import files: %w", err)
+	}
+	return imp, tx.Commit(ctx)
+}
+
+// RunImport runs an import (it's called by the run_import job and the import command). it fails
+// with ErrImportRunning if the import is already running.
+func (s *Server) RunImport(ctx context.Context, id int32, concurrency int) error {
+	n, err := s.Queries.StartImport(ctx, id)
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrImportRunning
+	}
+	defer keepLease("import", id, func(ctx context.Context) error { return s.Queries.TouchImport(ctx, id) })()
+	return s.runImport(ctx, id, concurrency)
+}
+
+// runImport imports every pending or failed file of a claimed import, concurrency files at a
+// time. each file's result is stored as soon as it's done.
+func (s *Server) runImport(ctx context.Context, id int32, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = int(env.DefaultEnv.IMPORT_CONCURRENCY)
+	}
+	imp, err := s.Queries.GetImport(ctx, id)
+	if err != nil {
+		return s.finishImport(id, fmt.Errorf("get import: %w", err))
+	}
+	names, err := s.Queries.ListUnfinishedImportFiles(ctx, id)
+	if err != nil {
+		return s.finishImport(id, fmt.Errorf("list import files: %w", err))
+	}
+	slog.Info("running import", "id", id, "source", imp.Source, "path", imp.Path, "files", len(names))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			key, photoID, err := s.importFile(ctx, imp, name)
+			result := db.SetImportFileResultParams{
+				ImportID: id,
+				Name:     name,
+				Status:   importFileImported,
+			}
+			if key != "" {
+				result.ObjectKey = pgText(key)
+			}
+			if photoID != 0 {
+				result.PhotoID = pgtype.Int4{Int32: photoID, Valid: true}
+			}
+			var skip skipError
+			if errors.As(err, &skip) {
+				result.Status, result.Error = importFileSkipped, pgText(skip.Error())
+			} else if err != nil {
+				slog.Error("import file", "import", id, "name", name, "error", err)
+				result.Status, result.Error = importFileFailed, pgText(err.Error())
+			}
+			// recorded even if ctx was cancelled, so finished files aren't imported twice
+			if err := s.Queries.SetImportFileResult(context.Background(), result); err != nil {
+				slog.Error("set import file result", "import", id, "name", name, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return s.finishImport(id, ctx.Err())
+}
+
+// finishImport records how an import ended. imports stopped by a cancelled context are marked
+// interrupted so they can be resumed.
+func (s *Server) finishImport(id int32, err error) error {
+	params := db.FinishImportParams{ID: id, Status: "done"}
+	if errors.Is(err, context.Canceled) {
+		params.Status, params.Error = "interrupted", pgText(err.Error())
+	} else if err != nil {
+		params.Status, params.Error = "failed", pgText(err.Error())
+	}
+	if ferr := s.Queries.FinishImport(context.Background(), params); ferr != nil {
+		slog.Error("finish import", "id", id, "error", ferr)
+	}
+	return err
+}
+
+// skipError is returned by importFile for files that are deliberately not imported.
+type skipError struct{ reason error }
+
+func (e skipError) Error() string { return e.reason.Error() }
+
+// importFile imports a single file, returning the key of its object and the created photo.
+func (s *Server) importFile(ctx context.Context, imp db.Import, name string) (string, int32, error) {
+	if imp.Source == ImportFromLocal {
+		return s.importLocalFile(ctx, imp, name)
+	}
+	photoID, err := s.importObject(ctx, imp, name)
+	return name, photoID, err
+}
+
+// importObject creates a photo for an object already in the bucket, extracting its metadata and
+// pushing it to the object.
+func (s *Server) importObject(ctx context.Context, imp db.Import, key string) (int32, error) {
+	if err := s.checkNotImported(ctx, key); err != nil {
+		return 0, err
+	}
+	head, err := bucket.GetObjectRange(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key, metadataReadSize)
+	if err != nil {
+		return 0, err
+	}
+	info, err := validateImage(bytes.NewReader(head))
+	if errors.Is(err, errUnsupportedImage) {
+		return 0, skipError{err}
+	} else if err != nil {
+		return 0, err
+	}
+	md, err := metadata(bytes.NewReader(head))
+	if err != nil {
+		return 0, fmt.Errorf("extract metadata: %w", err)
+	}
+	if err := bucket.ReplaceObjectMetadata(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key, md, objectHeaders(info.Format, key)); err != nil {
+		return 0, fmt.Errorf("update object metadata: %w", err)
+	}
+	photoID, _, err := s.createPhoto(ctx, "", bucket.PublicURL(key), "", md, imp.Tags)
+	return photoID, err
+}
+
+// importLocalFile uploads a local file (unless an object with its key already exists) and creates
+// a photo for it.
+func (s *Server) importLocalFile(ctx context.Context, imp db.Import, name string) (string, int32, error) {
+	filename := filepath.Join(imp.Path, filepath.FromSlash(name))
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	} else if stat.Size() > env.DefaultEnv.MAX_UPLOAD_SIZE {
+		return "", 0, fmt.Errorf("file is larger than %d bytes", env.DefaultEnv.MAX_UPLOAD_SIZE)
+	}
+
+	info, err := validateImage(file)
+	if errors.Is(err, errUnsupportedImage) {
+		return "", 0, skipError{err}
+	} else if err != nil {
+		return "", 0, err
+	}
+	var hash string
+	if strings.Contains(env.DefaultEnv.R2_PHOTOS_KEY_TEMPLATE, "{hash}") {
+		if hash, err = contentHash(file); err != nil {
+			return "", 0, fmt.Errorf("hash file: %w", err)
+		}
+	}
+	if filepath.Ext(name) == "" {
+		name += info.Format.Ext
+	}
+	key, err := bucket.ApplyKeyTemplate(env.DefaultEnv.R2_PHOTOS_KEY_TEMPLATE, name, hash, stat.ModTime())
+	if err != nil {
+		return "", 0, err
+	}
+	if err := s.checkNotImported(ctx, key); err != nil {
+		return key, 0, err
+	}
+
+	md, err := metadata(file)
+	if err != nil {
+		return key, 0, fmt.Errorf("extract metadata: %w", err)
+	}
+	if sidecar := findSidecar(filename); sidecar != "" {
+		if err := mergeSidecarFile(md, sidecar); err != nil {
+			return key, 0, fmt.Errorf("read sidecar %s: %w", filepath.Base(sidecar), err)
+		}
+	}
+	if err := rewind(file); err != nil {
+		return key, 0, err
+	}
+	// files that are already in the bucket (e.g. from an earlier, interrupted import) aren't uploaded again
+	err = putPhotoObject(ctx, key, md, objectHeaders(info.Format, key), file, stat.Size(), false)
+	if errors.Is(err, bucket.ErrObjectExists) {
+		err = checkSameObject(ctx, key, hash, file, stat.Size())
+	}
+	if err != nil {
+		return key, 0, fmt.Errorf("upload: %w", err)
+	}
+	photoID, _, err := s.createPhoto(ctx, "", bucket.PublicURL(key), "", md, imp.Tags)
+	return key, photoID, err
+}
+
+// checkSameObject returns an error unless the object at key has the same content as file. keys
+// without {hash} can collide with unrelated objects (e.g. IMG_0001.jpg from another camera), so
+// unless the key was built from the content hash, the object's size and ETag are compared.
+func checkSameObject(ctx context.Context, key, hash string, file io.ReaderAt, size int64) error {
+	if hash != "" {
+		return nil
+	}
+	etag, objectSize, err := bucket.ObjectETag(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, key)
+	if err != nil {
+		return err
+	}
+	if objectSize == size {
+		want, err := bucket.ContentETag(file, size)
+		if err != nil {
+			return fmt.Errorf("hash file: %w", err)
+		} else if want == etag {
+			return nil
+		}
+	}
+	return fmt.Errorf("a different object already exists at %s", key)
+}
+
+// checkNotImported returns a skipError if a photo already uses the object.
+func (s *Server) checkNotImported(ctx context.Context, key string) error {
+	count, err := s.Queries.CountPhotosWithURL(ctx, bucket.PublicURL(key))
+	if err != nil {
+		return fmt.Errorf("count photos with url: %w", err)
+	} else if count > 0 {
+		return skipError{errAlreadyPhoto}
+	}
+	return nil
+}
+
+// listLocalImportFiles lists the files under dir as slash separated relative paths. hidden files
+// and .xmp sidecars are left out.
+func listLocalImportFiles(dir string) ([]string, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	} else if !stat.IsDir() {
+		return nil, &fs.PathError{Op: "import", Path: dir, Err: errors.New("not a directory")}
+	}
+	var names []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.EqualFold(filepath.Ext(p), ".xmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// findSidecar returns the XMP sidecar of a file (IMG_1.xmp or IMG_1.dng.xmp), or "" if it has none.
+func findSidecar(filename string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, candidate := range []string{base + ".xmp", base + ".XMP", filename + ".xmp", filename + ".XMP"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func mergeSidecarFile(md map[string]string, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mergeXMPSidecar(md, f)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+	"unicode/utf8"
+)
+
+// IPTC-IIM application record (2:xx) datasets
+const (
+	iptcObjectName  = 5   // title
+	iptcKeywords    = 25  // repeatable
+	iptcDateCreated = 55  // CCYYMMDD
+	iptcTimeCreated = 60  // HHMMSS±HHMM
+	iptcByline      = 80  // author
+	iptcCaption     = 120 // description
+)
+
+// iptcRecords maps application record datasets to their values (some datasets repeat).
+type iptcRecords map[byte][]string
+
+func (r iptcRecords) first(dataset byte) string {
+	if values := r[dataset]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// dateCreated combines the DateCreated and TimeCreated datasets.
+func (r iptcRecords) dateCreated() (time.Time, bool) {
+	date, clock := r.first(iptcDateCreated), r.first(iptcTimeCreated)
+	if date == "" {
+		return time.Time{}, false
+	}
+	if clock != "" {
+		if t, err := time.Parse("20060102150405-0700", date+clock); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse("20060102150405", date+clock); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.Parse("20060102", date)
+	return t, err == nil
+}
+
+// parseIPTC finds the IPTC-IIM block in an image and returns its application records, or nil if
+// there isn't one. IPTC is stored in a Photoshop "8BIM" image resource with ID 0x0404, in the APP13
+// segment of JPEGs and the Photoshop tag of TIFFs, so searching for the resource works for both.
+func parseIPTC(data []byte) iptcRecords {
+	i := bytes.Index(data, []byte("8BIM\x04\x04"))
+	if i < 0 {
+		return nil
+	}
+	b := data[i+6:]
+	if len(b) < 1 {
+		return nil
+	}
+	// resource name is a pascal string padded to an even length
+	nameLen := int(b[0]) + 1
+	nameLen += nameLen % 2
+	if len(b) < nameLen+4 {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(b[nameLen : nameLen+4]))
+	b = b[nameLen+4:]
+	b = b[:min(size, len(b))]
+
+	records := make(iptcRecords)
+	// each dataset is 0x1C, record number, dataset number, 2 byte length, value
+	for len(b) >= 5 && b[0] == 0x1C {
+		record, dataset := b[1], b[2]
+		n := int(binary.BigEndian.Uint16(b[3:5]))
+		if n&0x8000 != 0 || 5+n > len(b) {
+			// extended-length datasets are only used for binary data we don't read
+			break
+		}
+		value := b[5 : 5+n]
+		b = b[5+n:]
+		if record == 2 {
+			records[dataset] = append(records[dataset], iptcString(value))
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return records
+}
+
+// iptcString decodes an IPTC value. modern writers use UTF-8, older ones Latin-1.
+func iptcString(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
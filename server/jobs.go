@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// job kinds
+const (
+	jobExtractMetadata = "extract_metadata" // re-extract a photo's metadata from its original (extractMetadataJob)
+	jobRunImport       = "run_import"       // run or resume a bulk import (runImportJob)
+)
+
+type extractMetadataJob struct {
+	PhotoID int32 `json:"photo_id"`
+}
+
+type runImportJob struct {
+	ImportID    int32 `json:"import_id"`
+	Concurrency int   `json:"concurrency"` // 0 uses IMPORT_CONCURRENCY
+}
+
+const (
+	jobMaxAttempts  = 5                // attempts before a job is marked failed
+	jobPollInterval = time.Second      // how often idle workers check for jobs
+	jobBaseBackoff  = 10 * time.Second // delay before the first retry; doubled on every attempt
+	jobMaxBackoff   = time.Hour
+)
+
+// a process running a job or an import holds a lease on it, which it renews every
+// leaseRenewInterval (jobs.locked_at and imports.heartbeat_at). jobs and imports whose lease is
+// older than leaseTimeout were left running by a process that stopped, and are taken back.
+const (
+	leaseTimeout       = 5 * time.Minute
+	leaseRenewInterval = time.Minute
+)
+
+// jobHandlers run jobs by kind. a handler returning an error is retried with backoff, unless the
+// error is a permanentError.
+var jobHandlers = map[string]func(s *Server, ctx context.Context, payload json.RawMessage) error{
+	jobExtractMetadata: func(s *Server, ctx context.Context, payload json.RawMessage) error {
+		var job extractMetadataJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return permanentError{err}
+		}
+		photo, err := s.Queries.GetPhotoByIDWithTags(ctx, job.PhotoID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return permanentError{fmt.Errorf("photo %d not found", job.PhotoID)}
+		} else if err != nil {
+			return err
+		}
+		md, err := extractPhotoMetadata(ctx, photo.PhotoUrl)
+		if err != nil {
+			return err
+		}
+		if err := s.storePhotoMetadata(ctx, job.PhotoID, md); errors.Is(err, pgx.ErrNoRows) {
+			return permanentError{fmt.Errorf("photo %d not found", job.PhotoID)}
+		} else if err != nil {
+			return err
+		}
+		return nil
+	},
+	jobRunImport: func(s *Server, ctx context.Context, payload json.RawMessage) error {
+		var job runImportJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return permanentError{err}
+		}
+		if err := s.RunImport(ctx, job.ImportID, job.Concurrency); errors.Is(err, ErrImportRunning) {
+			return permanentError{err}
+		} else if err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// permanentError is returned by job handlers for failures that retrying won't fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// enqueueJob adds a job to the queue. queries can be a transaction's queries, so the job is only
+// enqueued if the transaction commits.
+func (s *Server) enqueueJob(ctx context.Context, queries *db.Queries, kind string, payload any) (int64, error) {
+	if _, ok := jobHandlers[kind]; !ok {
+		return 0, fmt.Errorf("unknown job kind %q", kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	return queries.EnqueueJob(ctx, db.EnqueueJobParams{
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: jobMaxAttempts,
+	})
+}
+
+// startJobWorkers starts n workers that run queued jobs until ctx is cancelled.
+func (s *Server) startJobWorkers(ctx context.Context, n int) {
+	for range n {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			s.jobWorker(ctx)
+		}()
+	}
+}
+
+// jobWorker claims and runs jobs one at a time. FOR UPDATE SKIP LOCKED in ClaimJob means workers
+// (including ones in other processes) never claim the same job.
+func (s *Server) jobWorker(ctx context.Context) {
+	for {
+		job, err := s.Queries.ClaimJob(ctx)
+		if err == nil {
+			s.runJob(ctx, job)
+			continue // there may be more jobs waiting
+		}
+		if !errors.Is(err, pgx.ErrNoRows) && ctx.Err() == nil {
+			slog.Error("claim job", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// runJob runs a claimed job and records the outcome. failed jobs are retried with exponential
+// backoff until they run out of attempts.
+func (s *Server) runJob(ctx context.Context, job db.Job) {
+	start := time.Now()
+	stopLease := keepLease("job", job.ID, func(ctx context.Context) error { return s.Queries.TouchJob(ctx, job.ID) })
+	err := callJobHandler(s, ctx, job)
+	stopLease()
+	// outcomes are recorded even if ctx was cancelled while the job ran
+	recordCtx := context.Background()
+	switch {
+	case err == nil:
+		slog.Info("job done", "id", job.ID, "kind", job.Kind, "took", time.Since(start))
+		err = s.Queries.CompleteJob(recordCtx, job.ID)
+	case ctx.Err() != nil:
+		// the server is shutting down; the job is claimed again once workers start back up
+		slog.Warn("job interrupted", "id", job.ID, "kind", job.Kind, "error", err)
+		err = s.Queries.RescheduleJob(recordCtx, db.RescheduleJobParams{
+			ID:             job.ID,
+			LastError:      pgText("interrupted by shutdown: " + err.Error()),
+			BackoffSeconds: 0,
+		})
+	case errors.As(err, new(permanentError)) || job.Attempts >= job.MaxAttempts:
+		slog.Error("job failed", "id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+		err = s.Queries.FailJob(recordCtx, db.FailJobParams{ID: job.ID, LastError: pgText(err.Error())})
+	default:
+		backoff := jobBackoff(job.Attempts)
+		slog.Warn("job failed, retrying", "id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "retry_in", backoff, "error", err)
+		err = s.Queries.RescheduleJob(recordCtx, db.RescheduleJobParams{
+			ID:             job.ID,
+			LastError:      pgText(err.Error()),
+			BackoffSeconds: int64(backoff.Seconds()),
+		})
+	}
+	if err != nil {
+		slog.Error("record job outcome", "id", job.ID, "error", err)
+	}
+}
+
+// startLeaseReaper takes back jobs and imports whose lease has expired, at startup and then every
+// leaseRenewInterval until ctx is cancelled. expired jobs are requeued and expired imports are
+// marked interrupted, so they can be resumed.
+func (s *Server) startLeaseReaper(ctx context.Context) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := s.Queries.RequeueExpiredJobs(ctx, int64(leaseTimeout.Seconds())); err != nil && ctx.Err() == nil {
+				slog.Error("requeue expired jobs", "error", err)
+			} else if n > 0 {
+				slog.Info("requeued jobs whose worker stopped", "count", n)
+			}
+			if n, err := s.Queries.InterruptStaleImports(ctx, int64(leaseTimeout.Seconds())); err != nil && ctx.Err() == nil {
+				slog.Error("interrupt stale imports", "error", err)
+			} else if n > 0 {
+				slog.Info("marked imports whose process stopped as interrupted", "count", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// keepLease renews the lease on a running job or import every leaseRenewInterval, until the
+// returned function is called.
+func keepLease[ID int32 | int64](kind string, id ID, renew func(ctx context.Context) error) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := renew(ctx); err != nil && ctx.Err() == nil {
+					slog.Error("renew lease", "kind", kind, "id", id, "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// callJobHandler runs a job's handler, turning panics into errors.
+func callJobHandler(s *Server, ctx context.Context, job db.Job) (err error) {
+	fn, ok := jobHandlers[job.Kind]
+	if !ok {
+		return permanentError{fmt.Errorf("unknown job kind %q", job.Kind)}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(s, ctx, job.Payload)
+}
+
+// jobBackoff is the delay before retrying a job that failed on its nth attempt, with up to 10%
+// jitter so failed jobs don't all retry at once.
+func jobBackoff(attempts int32) time.Duration {
+	backoff := jobMaxBackoff
+	if attempts < 20 {
+		backoff = min(jobBaseBackoff<<max(attempts-1, 0), jobMaxBackoff)
+	}
+	return backoff + rand.N(backoff/10+1)
+}
+
+// GET /api/v1/admin/jobs
+func (s *Server) listJobsHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Status string `query:"status" required:"false" enum:"pending,running,done,failed"` // only jobs with this status
+		Limit  int32  `query:"limit"`                                                      // maximum number of jobs (default 100, at most 1000)
+	}) error {
+		ctx := c.Request().Context()
+		if req.Limit <= 0 {
+			req.Limit = 100
+		}
+		jobs, err := s.Queries.ListJobs(ctx, db.ListJobsParams{
+			Status:   req.Status,
+			RowLimit: min(req.Limit, 1000),
+		})
+		if err != nil {
+			slog.Error("list jobs", "error", err)
+			return internalError()
+		}
+		counts, err := s.Queries.CountJobsByStatus(ctx)
+		if err != nil {
+			slog.Error("count jobs", "error", err)
+			return internalError()
+		}
+		byStatus := map[string]int64{"pending": 0, "running": 0, "done": 0, "failed": 0}
+		for _, count := range counts {
+			byStatus[count.Status] = count.Count
+		}
+		return c.JSON(200, echo.Map{
+			"jobs":   jobs,
+			"counts": byStatus,
+		})
+	})
+}
+
+// GET /api/v1/admin/jobs/:id
+func (s *Server) getJobHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID int64 `param:"id"`
+	}) error {
+		job, err := s.Queries.GetJob(c.Request().Context(), req.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("job not found")
+		} else if err != nil {
+			slog.Error("get job", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, job)
+	})
+}
+
+// POST /api/v1/admin/jobs/:id/retry
+//
+// runs a failed job again, with a fresh set of attempts.
+func (s *Server) retryJobHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID int64 `param:"id"`
+	}) error {
+		ctx := c.Request().Context()
+		n, err := s.Queries.RetryFailedJob(ctx, req.ID)
+		if err != nil {
+			slog.Error("retry job", "error", err)
+			return internalError()
+		} else if n == 0 {
+			if _, err := s.Queries.GetJob(ctx, req.ID); errors.Is(err, pgx.ErrNoRows) {
+				return notFound("job not found")
+			}
+			return conflict("only failed jobs can be retried")
+		}
+		return c.JSON(200, map[string]string{"success": "job queued for retry"})
+	})
+}
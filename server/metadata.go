@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanoberholster/imagemeta"
+	"github.com/evanoberholster/imagemeta/exif2"
+	"github.com/evanoberholster/imagemeta/meta"
+	"github.com/evanoberholster/imagemeta/xmp"
+)
+
+// metadataReadSize is how much of an object is read to extract its metadata. EXIF, XMP and IPTC
+// live near the start of the file for every format we handle.
+const metadataReadSize = 4 << 20
+
+// metadataKeys are the keys set by metadata. migrations/0002_photo_dates.sql has the same list.
+var metadataKeys = []string{
+	"CreatedAt", "LensMake", "LensModel", "CameraMake", "CameraModel", "Aperture", "FocalLength",
+	"ISO", "ShutterSpeed", "ImageType", "ImageWidth", "ImageHeight", "Latitude", "Longitude", "Altitude",
+	"ExifAvailable", "MetadataSources", "Title", "Description", "Keywords", "Rating",
+}
+
+// canonicalMetadata restores the casing of known metadata keys. the bucket lowercases metadata keys
+// (e.g. "CameraModel" comes back as "cameramodel"), so metadata read from an object goes through
+// this before being stored in photos.metadata. unknown keys are kept as they are.
+func canonicalMetadata(md map[string]string) map[string]string {
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		for _, known := range metadataKeys {
+			if strings.EqualFold(k, known) {
+				k = known
+				break
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// metadata extracts metadata from an image. EXIF is the primary source; embedded XMP and IPTC fill
+// in whatever EXIF doesn't have, along with the title, description, keywords and rating set in
+// editors like Lightroom. images without readable EXIF (screenshots, scans, most PNGs) still
+// get their format and dimensions from the image header, with ExifAvailable set to "false".
+// MetadataSources lists the sources that were found. only read errors are returned.
+func metadata(file io.ReadSeeker) (map[string]string, error) {
+	md := make(map[string]string)
+	var sources []string
+
+	exif, err := imagemeta.Decode(file)
+	if err == nil && !hasExif(exif) {
+		err = errors.New("exif is empty")
+	}
+	if err == nil {
+		md = map[string]string{
+			"CreatedAt":    exif.CreateDate().String(),
+			"LensMake":     exif.LensMake,
+			"LensModel":    exif.LensModel,
+			"CameraMake":   exif.CameraMake.String(),
+			"CameraModel":  exif.CameraModel.String(),
+			"Aperture":     fmt.Sprintf("%f", exif.FNumber),
+			"FocalLength":  fmt.Sprintf("%f", exif.FocalLength),
+			"ISO":          fmt.Sprintf("%d", exif.ISOSpeed),
+			"ShutterSpeed": meta.ExposureTime(exif.ExposureTime).String(),
+			"ImageType":    exif.ImageType.String(),
+			"ImageWidth":   fmt.Sprintf("%d", exif.ImageWidth),
+			"ImageHeight":  fmt.Sprintf("%d", exif.ImageHeight),
+			"Latitude":     fmt.Sprintf("%f", exif.GPS.Latitude()),
+			"Longitude":    fmt.Sprintf("%f", exif.GPS.Longitude()),
+			"Altitude":     fmt.Sprintf("%f", exif.GPS.Altitude()),
+		}
+		md["ExifAvailable"] = "true"
+		sources = append(sources, "exif")
+	} else {
+		slog.Info("no readable exif, falling back to other metadata sources", "error", err)
+		md["ExifAvailable"] = "false"
+	}
+
+	if err := rewind(file); err != nil {
+		return nil, err
+	}
+	head, err := io.ReadAll(io.LimitReader(file, metadataReadSize))
+	if err != nil {
+		return nil, err
+	}
+	if err := rewind(file); err != nil {
+		return nil, err
+	}
+
+	if x, err := xmp.ParseXmp(bytes.NewReader(head)); err == nil {
+		applyXMP(md, x, head, false)
+		sources = append(sources, "xmp")
+	}
+	if records := parseIPTC(head); records != nil {
+		applyIPTC(md, records)
+		sources = append(sources, "iptc")
+	}
+	if format, err := sniffImageFormat(head); err == nil {
+		setIfUnset(md, "ImageType", format.ContentType)
+		if width, height, err := imageDimensions(bytes.NewReader(head), format); err == nil && width > 0 {
+			setIfUnset(md, "ImageWidth", strconv.Itoa(width))
+			setIfUnset(md, "ImageHeight", strconv.Itoa(height))
+			sources = append(sources, "header")
+		}
+	}
+	md["MetadataSources"] = strings.Join(sources, ",")
+	return md, nil
+}
+
+// hasExif reports whether decoding found any EXIF. imagemeta doesn't fail on images without an
+// EXIF block, it returns zero values.
+func hasExif(exif exif2.Exif) bool {
+	return exif.CameraMake.String() != "" || exif.CameraModel.String() != "" ||
+		!exif.CreateDate().IsZero() || exif.ImageWidth != 0
+}
+
+// mergeXMPSidecar applies an XMP sidecar file (e.g. written by Lightroom next to a RAW file) to
+// md. values in the sidecar take priority over those embedded in the image.
+func mergeXMPSidecar(md map[string]string, sidecar io.Reader) error {
+	raw, err := io.ReadAll(io.LimitReader(sidecar, metadataReadSize))
+	if err != nil {
+		return err
+	}
+	x, err := xmp.ParseXmp(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	applyXMP(md, x, raw, true)
+	md["MetadataSources"] = strings.TrimPrefix(md["MetadataSources"]+",xmp-sidecar", ",")
+	return nil
+}
+
+// applyXMP copies camera, capture and caption fields from XMP into md. raw is the XMP packet
+// (or data containing it). existing values are only replaced if override is true.
+func applyXMP(md map[string]string, x xmp.XMP, raw []byte, override bool) {
+	set := setIfUnset
+	if override {
+		set = setIfNotEmpty
+	}
+	createdAt := x.Exif.DateTimeOriginal
+	if createdAt.IsZero() {
+		createdAt = x.Basic.CreateDate
+	}
+	if !createdAt.IsZero() {
+		set(md, "CreatedAt", createdAt.String())
+	}
+	set(md, "CameraMake", x.Tiff.Make)
+	set(md, "CameraModel", x.Tiff.Model)
+	set(md, "LensModel", x.Aux.Lens)
+	if x.Exif.Aperture != 0 {
+		set(md, "Aperture", fmt.Sprintf("%f", x.Exif.Aperture))
+	}
+	if x.Exif.FocalLength != 0 {
+		set(md, "FocalLength", fmt.Sprintf("%f", x.Exif.FocalLength))
+	}
+	if x.Exif.ISOSpeedRatings != 0 {
+		set(md, "ISO", fmt.Sprintf("%d", x.Exif.ISOSpeedRatings))
+	}
+	if x.Exif.ExposureTime != 0 {
+		set(md, "ShutterSpeed", x.Exif.ExposureTime.String())
+	}
+	if x.Exif.PixelXDimension != 0 && x.Exif.PixelYDimension != 0 {
+		set(md, "ImageWidth", fmt.Sprintf("%d", x.Exif.PixelXDimension))
+		set(md, "ImageHeight", fmt.Sprintf("%d", x.Exif.PixelYDimension))
+	}
+	if x.Exif.GPSLatitude != 0 || x.Exif.GPSLongitude != 0 {
+		set(md, "Latitude", fmt.Sprintf("%f", x.Exif.GPSLatitude))
+		set(md, "Longitude", fmt.Sprintf("%f", x.Exif.GPSLongitude))
+		set(md, "Altitude", fmt.Sprintf("%f", x.Exif.GPSAltitude))
+	}
+	if len(x.DC.Title) > 0 {
+		set(md, "Title", strings.TrimSpace(x.DC.Title[0]))
+	}
+	if len(x.DC.Description) > 0 {
+		set(md, "Description", strings.TrimSpace(x.DC.Description[0]))
+	}
+	set(md, "Keywords", joinKeywords(x.DC.Subject))
+	set(md, "Rating", xmpRating(raw))
+}
+
+// matches xmp:Rating as an attribute or an element
+var xmpRatingRegexp = regexp.MustCompile(`xmp:Rating(?:="|>)(-?[0-9])`)
+
+// xmpRating returns the xmp:Rating in raw, or "" if it's unrated. it's read directly because
+// imagemeta parses every positive rating as 0.
+func xmpRating(raw []byte) string {
+	m := xmpRatingRegexp.FindSubmatch(raw)
+	if m == nil || string(m[1]) == "0" {
+		return ""
+	}
+	return string(m[1])
+}
+
+// applyIPTC fills in the capture date, title, caption and keywords from IPTC if nothing else
+// provided them.
+func applyIPTC(md map[string]string, records iptcRecords) {
+	if t, ok := records.dateCreated(); ok {
+		setIfUnset(md, "CreatedAt", t.String())
+	}
+	setIfUnset(md, "Title", strings.TrimSpace(records.first(iptcObjectName)))
+	setIfUnset(md, "Description", strings.TrimSpace(records.first(iptcCaption)))
+	setIfUnset(md, "Keywords", joinKeywords(records[iptcKeywords]))
+}
+
+// keywords are stored in metadata as a single comma separated value (Lightroom doesn't allow
+// commas in keywords).
+func joinKeywords(keywords []string) string {
+	return strings.Join(metadataKeywords(strings.Join(keywords, ",")), ", ")
+}
+
+// metadataKeywords splits the Keywords metadata value, dropping blanks and duplicates.
+func metadataKeywords(value string) []string {
+	var keywords []string
+	for kw := range strings.SplitSeq(value, ",") {
+		if kw = strings.TrimSpace(kw); kw != "" && !slices.Contains(keywords, kw) {
+			keywords = append(keywords, kw)
+		}
+	}
+	return keywords
+}
+
+// setIfUnset sets md[key] unless it already has a meaningful value. EXIF decoding fills missing
+// numbers with zero, so zero values count as unset.
+func setIfUnset(md map[string]string, key, value string) {
+	if value == "" || !isUnset(md[key]) {
+		return
+	}
+	md[key] = value
+}
+
+func setIfNotEmpty(md map[string]string, key, value string) {
+	if value != "" {
+		md[key] = value
+	}
+}
+
+func isUnset(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" || value == (time.Time{}).String() {
+		return true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return err == nil && f == 0
+}
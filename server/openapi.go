@@ -0,0 +1,593 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/bucket"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// apiGroup is the /api/v1 route group. it records every route registered through it so the
+// OpenAPI spec can be generated from the route table.
+type apiGroup struct {
+	*echo.Group
+	routes []apiRoute
+}
+
+type apiRoute struct {
+	method      string
+	path        string
+	handler     echo.HandlerFunc
+	middlewares []echo.MiddlewareFunc
+}
+
+func (g *apiGroup) add(method, path string, h echo.HandlerFunc, m []echo.MiddlewareFunc) *echo.Route {
+	g.routes = append(g.routes, apiRoute{method, path, h, m})
+	return g.Group.Add(method, path, h, m...)
+}
+
+func (g *apiGroup) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodGet, path, h, m)
+}
+
+func (g *apiGroup) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPost, path, h, m)
+}
+
+func (g *apiGroup) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPut, path, h, m)
+}
+
+func (g *apiGroup) PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPatch, path, h, m)
+}
+
+func (g *apiGroup) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodDelete, path, h, m)
+}
+
+// describeHandlerKey is set on the context to ask a handler[T] to describe itself (see describe)
+// instead of handling a request.
+const describeHandlerKey = "describe_handler"
+
+type handlerDescription struct {
+	name    string       // e.g. "addTag" for (*Server).addTagHandler
+	request reflect.Type // T
+}
+
+// describe returns what's known about a route's handler. handlers made by handler[T] are asked
+// for their request type; other handlers are only named.
+func describe(h echo.HandlerFunc) handlerDescription {
+	fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	if !strings.Contains(fn, ".handler[") {
+		return handlerDescription{name: operationName(fn)}
+	}
+	var d handlerDescription
+	c := echo.New().NewContext(nil, nil)
+	c.Set(describeHandlerKey, &d)
+	_ = h(c)
+	return d
+}
+
+// operationName turns a function name like ".../server.(*Server).addTagHandler-fm" into "addTag".
+func operationName(fn string) string {
+	fn = fn[strings.LastIndex(fn, ".")+1:]
+	fn = strings.TrimSuffix(fn, "-fm")
+	return strings.TrimSuffix(fn, "Handler")
+}
+
+func middlewareName(m echo.MiddlewareFunc) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name()
+	return strings.TrimSuffix(fn[strings.LastIndex(fn, ".")+1:], "-fm")
+}
+
+// the OpenAPI 3 document. only the parts we use are modelled.
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       map[string]string                       `json:"info"`
+	Servers    []map[string]string                     `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema `json:"schemas"`
+	Responses       map[string]any            `json:"responses"`
+	SecuritySchemes map[string]any            `json:"securitySchemes"`
+}
+
+type openAPIOperation struct {
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags"`
+	Description string                `json:"description,omitempty"`
+	Parameters  []openAPIParameter    `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody   `json:"requestBody,omitempty"`
+	Responses   map[string]any        `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"` // path or query
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                                 `json:"required"`
+	Content  map[string]map[string]*openAPISchema `json:"content"` // media type -> {"schema": ...}
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+}
+
+var pathParamRegexp = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// openAPISpec generates the spec for the routes registered so far.
+func (g *apiGroup) openAPISpec() *openAPIDoc {
+	doc := &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "ajitesh.cc API", "version": "1"},
+		Servers: []map[string]string{{"url": "/api/v1"}},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{},
+			Responses: map[string]any{
+				"Error": map[string]any{
+					"description": "error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": map[string]string{"$ref": "#/components/schemas/APIError"}}},
+				},
+			},
+			SecuritySchemes: map[string]any{
+				"adminCookie": map[string]string{"type": "apiKey", "in": "cookie", "name": "admin_token"},
+			},
+		},
+	}
+	schemas := schemaBuilder{components: doc.Components.Schemas, types: map[string]reflect.Type{}}
+	schemas.schema(reflect.TypeFor[APIError]())
+	responseSchemas := schemas
+	responseSchemas.response = true
+
+	described := map[string]bool{}
+	for _, route := range g.routes {
+		path := pathParamRegexp.ReplaceAllString(route.path, "{$1}")
+		d := describe(route.handler)
+		id := d.name
+		if !strings.HasPrefix(id, strings.ToLower(route.method)) { // getAllPhotos stays as is
+			id = strings.ToLower(route.method) + strings.ToUpper(id[:1]) + id[1:]
+		}
+		response, ok := operationResponses[d.name]
+		if !ok {
+			panic("openapi: no response described for " + d.name + " (see operationResponses)")
+		}
+		described[d.name] = true
+		op := &openAPIOperation{
+			OperationID: id,
+			Tags:        []string{strings.Split(strings.TrimPrefix(route.path, "/"), "/")[0]},
+			Responses: map[string]any{
+				strconv.Itoa(response.status): responseSchemas.describeResponse(response),
+				"default":                     map[string]string{"$ref": "#/components/responses/Error"},
+			},
+		}
+		for _, m := range route.middlewares {
+			switch middlewareName(m) {
+			case "RequireAdminMiddleware":
+				op.Security = []map[string][]string{{"adminCookie": {}}}
+				op.Description = "admin only."
+			case "IsAdminMiddleware":
+				op.Description = "admins see more (e.g. unpublished posts)."
+			case "Middleware": // RateLimiter.Middleware
+				op.Description = "rate limited."
+			}
+		}
+		if d.request != nil {
+			schemas.describeRequest(op, d.request)
+		}
+		// handlers that aren't made by handler[T] read path parameters themselves, so every
+		// parameter in the path is described even if the request type doesn't have it
+		for _, m := range pathParamRegexp.FindAllStringSubmatch(route.path, -1) {
+			if !slices.ContainsFunc(op.Parameters, func(p openAPIParameter) bool { return p.In == "path" && p.Name == m[1] }) {
+				op.Parameters = append(op.Parameters, openAPIParameter{Name: m[1], In: "path", Required: true, Schema: &openAPISchema{Type: "string"}})
+			}
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(route.method)] = op
+	}
+	for name := range operationResponses {
+		if !described[name] {
+			panic("openapi: response described for " + name + ", which isn't a route")
+		}
+	}
+	return doc
+}
+
+// openAPIResponse is what an operation returns when it succeeds.
+type openAPIResponse struct {
+	status int
+	bodies []reflect.Type // none for an empty body; more than one if the body depends on the request
+}
+
+func responds(status int, bodies ...reflect.Type) openAPIResponse {
+	return openAPIResponse{status, bodies}
+}
+
+// resultOf is the type a query method (e.g. (*db.Queries).ListTags) returns.
+func resultOf(query any) reflect.Type {
+	return reflect.TypeOf(query).Out(0)
+}
+
+// object is a struct with the given json fields (names may have ",omitempty"), for bodies sent as
+// echo.Map.
+func object(fields map[string]reflect.Type) reflect.Type {
+	structFields := make([]reflect.StructField, 0, len(fields))
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		structFields = append(structFields, reflect.StructField{
+			Name: "F" + strconv.Itoa(len(structFields)),
+			Type: fields[name],
+			Tag:  reflect.StructTag(`json:"` + name + `"`),
+		})
+	}
+	return reflect.StructOf(structFields)
+}
+
+// operationResponses has the successful response of every route, by operation name (see
+// describe). openAPISpec panics if a route is missing.
+var operationResponses = map[string]openAPIResponse{
+	// photos
+	"getAllPhotos":  responds(200, resultOf((*db.Queries).GetAllPhotosWithTags)),
+	"getPhotoStats": responds(200, reflect.TypeFor[photoStats]()),
+	"getPhotoArchive": responds(200, object(map[string]reflect.Type{
+		"timezone": reflect.TypeFor[string](),
+		"years":    reflect.TypeFor[[]archiveYear](),
+	})),
+	"getPhotoArchiveMonth": responds(200, object(map[string]reflect.Type{
+		"year":     reflect.TypeFor[int](),
+		"month":    reflect.TypeFor[int](),
+		"timezone": reflect.TypeFor[string](),
+		"days":     reflect.TypeFor[[]archivePhotoDay](),
+	})),
+	"getPhotoByID":         responds(200, resultOf((*db.Queries).GetPhotoByIDWithTags)),
+	"addPhoto":             responds(201, reflect.TypeFor[addedPhoto]()),
+	"deletePhoto":          responds(204),
+	"updatePhotoMetadata":  responds(200, reflect.TypeFor[map[string]string]()),
+	"extractPhotoMetadata": responds(202, reflect.TypeFor[map[string]int64]()), // {"job_id": ...} when a job is queued
+	"addTagToPhoto":        responds(204),
+	"removeTagFromPhoto":   responds(204),
+
+	// objects
+	"listAllBucketPhotoObjects": responds(200, reflect.TypeFor[[]bucket.Object]()),
+	"uploadPhotoToBucket":       responds(200, reflect.TypeFor[map[string]string]()),
+	"updateObjectMetadata":      responds(200, reflect.TypeFor[map[string]string]()),
+	"deleteObject":              responds(204),
+	"moveObject": responds(200, object(map[string]reflect.Type{
+		"name":              reflect.TypeFor[string](),
+		"photos_updated":    resultOf((*db.Queries).UpdatePhotoURL),
+		"warning,omitempty": reflect.TypeFor[string](),
+	})),
+	"listMultipartUploads":  responds(200, reflect.TypeFor[[]multipartUpload]()),
+	"createMultipartUpload": responds(200, reflect.TypeFor[bucket.MultipartUpload]()),
+	"listUploadedParts": responds(200, object(map[string]reflect.Type{
+		"parts":                 reflect.TypeFor[[]bucket.Part](),
+		"part_count":            reflect.TypeFor[int](),
+		"uploaded_bytes":        reflect.TypeFor[int64](),
+		"total_bytes,omitempty": reflect.TypeFor[int64](),
+	})),
+	"uploadPart":              responds(200, reflect.TypeFor[bucket.Part]()),
+	"completeMultipartUpload": responds(200, reflect.TypeFor[map[string]string]()),
+	"abortMultipartUpload":    responds(204),
+
+	// posts
+	"listPosts":         responds(200, resultOf((*db.Queries).ListPostsWithTags)),
+	"getPostBySlug":     responds(200, resultOf((*db.Queries).GetPostBySlugWithTags)),
+	"addPost":           responds(201),
+	"updatePost":        responds(200, resultOf((*db.Queries).UpdatePost)),
+	"deletePost":        responds(204),
+	"addTagToPost":      responds(204),
+	"removeTagFromPost": responds(204),
+
+	// tags
+	"listTags": responds(200,
+		resultOf((*db.Queries).ListTags),
+		reflect.TypeFor[[]*tagNode](),      // ?tree=true
+		reflect.TypeFor[[]tagWithCounts](), // ?with_counts=true
+	),
+	"addTag": responds(204),
+	"suggestTags": responds(200,
+		resultOf((*db.Queries).SuggestTags),
+		reflect.TypeFor[[]tagSuggestion](), // ?photo_id=
+	),
+	"bulkTag": responds(200, object(map[string]reflect.Type{
+		"created_tags": reflect.TypeFor[[]string](),
+		"results":      reflect.TypeFor[[]bulkTagResult](),
+	})),
+	"getTag": responds(200, object(map[string]reflect.Type{
+		"tag":         resultOf((*db.Queries).GetTag),
+		"photo_count": resultOf((*db.Queries).CountPhotosWithTag),
+		"post_count":  resultOf((*db.Queries).CountPublishedPostsWithTag),
+		"photos":      resultOf((*db.Queries).ListPhotosWithTagPage),
+		"posts":       resultOf((*db.Queries).ListPublishedPostsWithTagPage),
+		"page":        reflect.TypeFor[int32](),
+		"per_page":    reflect.TypeFor[int32](),
+	})),
+	"deleteTag":    responds(204),
+	"updateTag":    responds(200, resultOf((*db.Queries).UpdateTag)),
+	"mergeTag":     responds(204),
+	"getTagPhotos": responds(200, resultOf((*db.Queries).GetPhotosByTagTree)),
+	"getTagPosts":  responds(200, resultOf((*db.Queries).GetPostsByTagTree)),
+
+	// imports
+	"listImports":  responds(200, resultOf((*db.Queries).ListImports)),
+	"createImport": responds(202, resultOf((*db.Queries).CreateImport)),
+	"getImport": responds(200, object(map[string]reflect.Type{
+		"import":   resultOf((*db.Queries).GetImport),
+		"progress": reflect.TypeFor[map[string]int64](), // files by status
+		"files":    resultOf((*db.Queries).ListImportFiles),
+	})),
+	"resumeImport": responds(202, reflect.TypeFor[map[string]string]()),
+
+	// admin
+	"isAdmin":    responds(200, reflect.TypeFor[map[string]bool]()),
+	"adminLogin": responds(204),
+	"listJobs": responds(200, object(map[string]reflect.Type{
+		"jobs":   resultOf((*db.Queries).ListJobs),
+		"counts": reflect.TypeFor[map[string]int64](), // jobs by status
+	})),
+	"getJob":   responds(200, resultOf((*db.Queries).GetJob)),
+	"retryJob": responds(200, reflect.TypeFor[map[string]string]()),
+	"listTrash": responds(200, object(map[string]reflect.Type{
+		"photos":            resultOf((*db.Queries).ListDeletedPhotos),
+		"posts":             resultOf((*db.Queries).ListDeletedPosts),
+		"tags":              resultOf((*db.Queries).ListDeletedTags),
+		"objects":           reflect.TypeFor[[]bucket.Object](),
+		"retention_seconds": reflect.TypeFor[int64](),
+	})),
+	"restorePhoto":  responds(204),
+	"restorePost":   responds(204),
+	"restoreTag":    responds(204),
+	"restoreObject": responds(204),
+}
+
+type schemaBuilder struct {
+	components map[string]*openAPISchema
+	types      map[string]reflect.Type // the type behind each component, to catch name clashes
+	response   bool                    // every field without omitempty is sent, so it's required
+}
+
+// describeResponse is the OpenAPI response object for r.
+func (b schemaBuilder) describeResponse(r openAPIResponse) map[string]any {
+	response := map[string]any{"description": http.StatusText(r.status)}
+	if len(r.bodies) == 0 {
+		return response
+	}
+	schema := b.schema(r.bodies[0])
+	if len(r.bodies) > 1 {
+		schema = &openAPISchema{}
+		for _, t := range r.bodies {
+			schema.OneOf = append(schema.OneOf, b.schema(t))
+		}
+	}
+	response["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+	return response
+}
+
+// describeRequest adds a request type's path and query fields as parameters and its json or form
+// fields as the request body.
+func (b schemaBuilder) describeRequest(op *openAPIOperation, rt reflect.Type) {
+	body := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	mediaType := "application/json"
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		schema := b.fieldSchema(field)
+		required := fieldRequired(field)
+		switch {
+		case field.Tag.Get("param") != "":
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: field.Tag.Get("param"), In: "path", Required: true, Schema: schema})
+		case field.Tag.Get("query") != "":
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: field.Tag.Get("query"), In: "query", Required: required, Schema: schema})
+		case field.Tag.Get("json") != "" || field.Tag.Get("form") != "":
+			if field.Tag.Get("form") != "" {
+				mediaType = "multipart/form-data"
+			}
+			name := fieldName(field)
+			body.Properties[name] = schema
+			if required {
+				body.Required = append(body.Required, name)
+			}
+		}
+	}
+	if len(body.Properties) > 0 {
+		op.RequestBody = &openAPIRequestBody{
+			Required: len(body.Required) > 0,
+			Content:  map[string]map[string]*openAPISchema{mediaType: {"schema": body}},
+		}
+	}
+}
+
+// fieldSchema is the schema of a field's type with its validation tags (see handler).
+func (b schemaBuilder) fieldSchema(field reflect.StructField) *openAPISchema {
+	schema := b.schema(field.Type)
+	if schema.Ref != "" {
+		return schema
+	}
+	intTag := func(tag string) *int {
+		if n, err := strconv.Atoi(field.Tag.Get(tag)); err == nil {
+			return &n
+		}
+		return nil
+	}
+	floatTag := func(tag string) *float64 {
+		if n, err := strconv.ParseFloat(field.Tag.Get(tag), 64); err == nil {
+			return &n
+		}
+		return nil
+	}
+	if schema.Type == "array" || schema.Type == "object" {
+		schema.MinItems, schema.MaxItems = intTag("minlen"), intTag("maxlen")
+	} else {
+		schema.MinLength, schema.MaxLength = intTag("minlen"), intTag("maxlen")
+	}
+	schema.Minimum, schema.Maximum = floatTag("min"), floatTag("max")
+	target := schema
+	if schema.Type == "array" && schema.Items != nil && schema.Items.Type == "string" {
+		target = schema.Items // enum, pattern and format apply to every string in a slice
+	}
+	if enum, ok := field.Tag.Lookup("enum"); ok {
+		target.Enum = strings.Split(enum, ",")
+	}
+	if pattern, ok := field.Tag.Lookup("pattern"); ok {
+		target.Pattern = pattern
+	}
+	if field.Tag.Get("format") == "slug" {
+		target.Pattern = tagSlugRegexp.String()
+	}
+	return schema
+}
+
+// the JSON of pgtype types (all nullable), by type name
+var pgtypeSchemas = map[string]openAPISchema{
+	"Text":        {Type: "string"},
+	"Bool":        {Type: "boolean"},
+	"Int2":        {Type: "integer", Format: "int32"},
+	"Int4":        {Type: "integer", Format: "int32"},
+	"Int8":        {Type: "integer", Format: "int64"},
+	"Float4":      {Type: "number"},
+	"Float8":      {Type: "number"},
+	"Numeric":     {Type: "number"},
+	"Date":        {Type: "string", Format: "date"},
+	"Timestamp":   {Type: "string", Format: "date-time"},
+	"Timestamptz": {Type: "string", Format: "date-time"},
+	"UUID":        {Type: "string", Format: "uuid"},
+}
+
+var (
+	pgtypePkgPath = reflect.TypeFor[pgtype.Text]().PkgPath()
+	modulePath    = strings.TrimSuffix(serverPkgPath, "/server")
+)
+
+// schema returns the JSON schema of t. named structs declared in this module (including gen/db
+// rows) become components (referenced by $ref); other structs are inlined.
+func (b schemaBuilder) schema(t reflect.Type) *openAPISchema {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return &openAPISchema{} // any JSON
+	}
+	if t.PkgPath() == pgtypePkgPath {
+		schema := pgtypeSchemas[t.Name()] // unknown types are any JSON
+		schema.Nullable = true
+		return &schema
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := b.schema(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" || !strings.HasPrefix(t.PkgPath(), modulePath+"/") {
+			return b.structSchema(t)
+		}
+		ref := &openAPISchema{Ref: "#/components/schemas/" + t.Name()}
+		if other, ok := b.types[t.Name()]; ok && other != t {
+			panic("openapi: " + t.String() + " and " + other.String() + " would both be the " + t.Name() + " component")
+		}
+		b.types[t.Name()] = t
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = ref // placeholder, so self-referencing types (APIError) terminate
+			b.components[t.Name()] = b.structSchema(t)
+		}
+		return ref
+	}
+	return &openAPISchema{}
+}
+
+func (b schemaBuilder) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = b.fieldSchema(field)
+		if (b.response || fieldRequired(field)) && !strings.Contains(field.Tag.Get("json"), ",omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+//go:embed docs.html
+var docsHTML []byte
+
+// openAPIHandler serves the spec at GET /api/v1/openapi.json.
+func openAPIHandler(doc *openAPIDoc) echo.HandlerFunc {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic(err) // the document is made of plain types
+	}
+	return func(c echo.Context) error {
+		return c.JSONBlob(200, data)
+	}
+}
+
+// GET /api/v1/docs
+func docsHandler(c echo.Context) error {
+	return c.HTMLBlob(200, docsHTML)
+}
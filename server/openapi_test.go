@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&Server{}).Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var doc openAPIDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode spec: %v", err)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatal("spec has no paths")
+	}
+	pathParams := regexp.MustCompile(`\{([^}]+)\}`)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			for _, m := range pathParams.FindAllStringSubmatch(path, -1) {
+				if !slices.ContainsFunc(op.Parameters, func(p openAPIParameter) bool { return p.In == "path" && p.Name == m[1] && p.Required }) {
+					t.Errorf("%s %s: path parameter %s isn't described", method, path, m[1])
+				}
+			}
+			for status, response := range op.Responses {
+				if status == "default" {
+					continue
+				}
+				_, hasBody := response.(map[string]any)["content"]
+				if status == "204" && hasBody {
+					t.Errorf("%s %s: 204 response with a body", method, path)
+				} else if status == "200" && !hasBody {
+					t.Errorf("%s %s: 200 response without a body", method, path)
+				}
+			}
+		}
+	}
+}
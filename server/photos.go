@@ -1,9 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/labstack/echo/v4"
 	"github.com/tiredkangaroo/ajiteshcc/bucket"
@@ -15,7 +22,7 @@ func (s *Server) getAllPhotos(c echo.Context) error {
 	data, err := s.Queries.GetAllPhotosWithTags(c.Request().Context())
 	if err != nil {
 		slog.Error("get all photos with tags", "error", err)
-		return c.String(500, "internal server error")
+		return internalError()
 	}
 	return c.JSON(200, data)
 }
@@ -27,7 +34,7 @@ func (s *Server) getPhotoByIDHandler() echo.HandlerFunc {
 		data, err := s.Queries.GetPhotoByIDWithTags(c.Request().Context(), req.ID)
 		if err != nil {
 			slog.Error("get photo by id", "error", err)
-			return c.String(500, "internal server error")
+			return internalError()
 		}
 		return c.JSON(200, data)
 	})
@@ -40,14 +47,10 @@ func (s *Server) addPhotoHandler() echo.HandlerFunc {
 		Comment  string   `json:"comment" required:"false"`
 		Tags     []string `json:"tags" required:"false"`
 	}) error {
-		purl, err := url.Parse(req.PhotoURL)
+		objKey, err := objectKeyFromURL(req.PhotoURL)
 		if err != nil {
 			slog.Error("parse photo URL", "error", err)
-			return c.String(400, "bad request: invalid photo URL")
-		}
-		objKey := purl.Path
-		if len(objKey) > 0 && objKey[0] == '/' {
-			objKey = objKey[1:]
+			return badRequest("invalid photo URL")
 		}
 		// let's see if we can pull metadata from the photo URL
 		md, err := bucket.GetObjectMetadata(
@@ -57,56 +60,250 @@ func (s *Server) addPhotoHandler() echo.HandlerFunc {
 		)
 		if err != nil {
 			slog.Error("get object metadata", "error", err)
-			return c.String(500, "internal server error")
+			return internalError()
 		}
 
-		tx, err := s.Conn.Begin(c.Request().Context())
+		tags, err := s.resolveTagTitles(c.Request().Context(), req.Tags)
+		if err != nil {
+			slog.Error("resolve tags", "error", err)
+			return internalError()
+		}
+		// like bulk tagging, tags that don't exist don't fail the request; they're reported instead
+		missing, err := missingTags(c.Request().Context(), s.Queries, tags)
+		if err != nil {
+			slog.Error("list existing tags", "error", err)
+			return internalError()
+		}
+		tags = slices.DeleteFunc(tags, func(title string) bool { return slices.Contains(missing, title) })
+		id, skippedKeywords, err := s.createPhoto(c.Request().Context(), req.Title, req.PhotoURL, req.Comment, canonicalMetadata(md), tags)
 		if err != nil {
-			slog.Error("begin transaction", "error", err)
-			return c.String(500, "internal server error")
+			return dbError(err, "create photo") // e.g. a tag trashed since it was checked
+		}
+		skipped := append([]string{}, missing...)
+		for _, kw := range skippedKeywords {
+			if !slices.Contains(skipped, kw) {
+				skipped = append(skipped, kw)
+			}
 		}
-		defer tx.Rollback(c.Request().Context())
-		queries := s.Queries.WithTx(tx)
+		return c.JSON(201, addedPhoto{ID: id, SkippedTags: skipped})
+	})
+}
 
-		photoID, err := queries.AddPhoto(c.Request().Context(), db.AddPhotoParams{
-			Title:    pgText(req.Title),
-			PhotoUrl: req.PhotoURL,
-			Comment:  pgText(req.Comment),
-			Metadata: md,
-		})
+type addedPhoto struct {
+	ID          int32    `json:"id"`
+	SkippedTags []string `json:"skipped_tags"` // tags that don't exist, and tags or keywords in the trash; the photo was added without them
+}
 
-		if err != nil {
-			slog.Error("add photo", "error", err)
-			return c.String(500, "internal server error")
+// createPhoto adds a photo with its tags. titles, captions and keywords set in Lightroom (XMP/IPTC)
+// are used unless title and comment are set. keywords become tags, created if they don't exist yet;
+// keywords of trashed tags are skipped and returned, since those tags can't be recreated and the
+// photo would be invisibly tagged with them.
+func (s *Server) createPhoto(ctx context.Context, title, photoURL, comment string, md map[string]string, tags []string) (int32, []string, error) {
+	if title == "" {
+		title = md["Title"]
+	}
+	if comment == "" {
+		comment = md["Description"]
+	}
+	keywords := metadataKeywords(md["Keywords"])
+
+	tx, err := s.Conn.Begin(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	queries := s.Queries.WithTx(tx)
+
+	var skipped []string
+	if len(keywords) > 0 {
+		if skipped, err = queries.ListTrashedTagTitles(ctx, keywords); err != nil {
+			return 0, nil, fmt.Errorf("list trashed keyword tags: %w", err)
 		}
-		if err := queries.AddTagsToPhoto(context.Background(), db.AddTagsToPhotoParams{
-			PhotoID: photoID,
-			Column2: req.Tags,
-		}); err != nil {
-			slog.Error("add photo tags", "error", err)
-			return c.String(500, "internal server error")
+		keywords = slices.DeleteFunc(keywords, func(kw string) bool { return slices.Contains(skipped, kw) })
+		if err := queries.CreateTagsIfNotExist(ctx, keywords); err != nil {
+			return 0, nil, fmt.Errorf("create keyword tags: %w", err)
+		}
+	}
+	tags = slices.Clone(tags)
+	for _, kw := range keywords {
+		if !slices.Contains(tags, kw) {
+			tags = append(tags, kw)
+		}
+	}
+	photoID, err := queries.AddPhoto(ctx, db.AddPhotoParams{
+		Title:    pgText(title),
+		PhotoUrl: photoURL,
+		Comment:  pgText(comment),
+		Metadata: md,
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("add photo: %w", err)
+	}
+	if err := queries.AddTagsToPhoto(ctx, db.AddTagsToPhotoParams{
+		PhotoID: photoID,
+		Column2: tags,
+	}); err != nil {
+		return 0, nil, fmt.Errorf("add photo tags: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return photoID, skipped, nil
+}
+
+// PATCH /api/v1/photos/:id/metadata
+//
+// photos.metadata is authoritative: it's replaced and then pushed to the photo's object in the
+// bucket.
+func (s *Server) updatePhotoMetadataHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID       int32             `param:"id"`
+		Metadata map[string]string `json:"metadata"` // new metadata
+	}) error {
+		return s.setPhotoMetadata(c, req.ID, canonicalMetadata(req.Metadata))
+	})
+}
+
+// POST /api/v1/photos/:id/metadata/extract
+//
+// re-extracts metadata from the photo's original in the bucket and stores it on both the photo and
+// the object. with ?async=true it's done by a background job instead.
+func (s *Server) extractPhotoMetadataHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID    int32 `param:"id"`
+		Async bool  `query:"async"` // extract in a background job and respond with the job ID
+	}) error {
+		photo, err := s.Queries.GetPhotoByIDWithTags(c.Request().Context(), req.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("photo not found")
+		} else if err != nil {
+			slog.Error("get photo by id", "error", err)
+			return internalError()
+		}
+		if req.Async {
+			id, err := s.enqueueJob(c.Request().Context(), s.Queries, jobExtractMetadata, extractMetadataJob{PhotoID: req.ID})
+			if err != nil {
+				slog.Error("enqueue extract metadata job", "error", err)
+				return internalError()
+			}
+			return c.JSON(202, map[string]int64{"job_id": id})
 		}
+		md, err := extractPhotoMetadata(c.Request().Context(), photo.PhotoUrl)
+		if err != nil {
+			slog.Error("extract metadata", "error", err)
+			return newAPIError(422, "unprocessable", "unable to extract metadata from the original")
+		}
+		return s.setPhotoMetadata(c, req.ID, md)
+	})
+}
 
-		if err := tx.Commit(c.Request().Context()); err != nil {
-			slog.Error("commit transaction", "error", err)
-			return c.String(500, "internal server error")
+// extractPhotoMetadata extracts metadata from the original of a photo in the bucket.
+func extractPhotoMetadata(ctx context.Context, photoURL string) (map[string]string, error) {
+	objKey, err := objectKeyFromURL(photoURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse photo URL: %w", err)
+	}
+	head, err := bucket.GetObjectRange(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, objKey, metadataReadSize)
+	if err != nil {
+		return nil, err
+	}
+	return metadata(bytes.NewReader(head))
+}
+
+// setPhotoMetadata replaces a photo's metadata and pushes it to the photo's object.
+func (s *Server) setPhotoMetadata(c echo.Context, id int32, md map[string]string) error {
+	if err := s.storePhotoMetadata(c.Request().Context(), id, md); errors.Is(err, pgx.ErrNoRows) {
+		return notFound("photo not found")
+	} else if err != nil {
+		slog.Error("store photo metadata", "error", err)
+		return internalError()
+	}
+	return c.JSON(200, md)
+}
+
+// storePhotoMetadata replaces a photo's metadata and pushes it to the photo's object. the database
+// update is only committed once the bucket has been updated. it returns pgx.ErrNoRows if the photo
+// doesn't exist.
+func (s *Server) storePhotoMetadata(ctx context.Context, id int32, md map[string]string) error {
+	tx, err := s.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	queries := s.Queries.WithTx(tx)
+
+	photoURL, err := queries.UpdatePhotoMetadata(ctx, db.UpdatePhotoMetadataParams{
+		ID:       id,
+		Metadata: md,
+	})
+	if err != nil {
+		return err
+	}
+	objKey, err := objectKeyFromURL(photoURL)
+	if err != nil {
+		return fmt.Errorf("parse photo URL: %w", err)
+	}
+	if err := bucket.UpdateObjectMetadata(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, objKey, md); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// objectKeyFromURL returns the object key of a photo URL (its path, without the leading slash).
+func objectKeyFromURL(photoURL string) (string, error) {
+	purl, err := url.Parse(photoURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(purl.Path, "/"), nil
+}
+
+// DELETE /api/v1/photos/:id
+//
+// the photo is moved to the trash. its object in the bucket is left alone.
+func (s *Server) deletePhotoHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID int32 `param:"id"`
+	}) error {
+		n, err := s.Queries.DeletePhoto(c.Request().Context(), req.ID)
+		if err != nil {
+			slog.Error("delete photo", "error", err)
+			return internalError()
+		} else if n == 0 {
+			return notFound("photo not found")
 		}
 		return c.NoContent(204)
 	})
 }
 
 // PATCH /api/v1/photos/:id/tag/:title
+//
+// adding a tag the photo already has isn't an error. trashed photos and tags aren't found.
 func (s *Server) addTagToPhotoHandler() echo.HandlerFunc {
 	return handler(func(c echo.Context, req struct {
 		PhotoID  int32  `param:"id"`
 		TagTitle string `param:"title"`
 	}) error {
-		if err := s.Queries.AddTagToPhoto(c.Request().Context(), db.AddTagToPhotoParams{
+		ctx := c.Request().Context()
+		n, err := s.Queries.AddTagToPhoto(ctx, db.AddTagToPhotoParams{
 			PhotoID:  req.PhotoID,
 			TagTitle: req.TagTitle,
-		}); err != nil {
-			slog.Error("add tag to photo", "error", err)
-			return c.String(500, "internal server error")
+		})
+		if err != nil {
+			return dbError(err, "add tag to photo")
+		} else if n == 0 { // already tagged, or the photo or tag doesn't exist
+			if ids, err := s.Queries.ListExistingPhotoIDs(ctx, []int32{req.PhotoID}); err != nil {
+				slog.Error("list existing photos", "error", err)
+				return internalError()
+			} else if len(ids) == 0 {
+				return notFound("photo not found")
+			}
+			if _, err := s.Queries.GetTag(ctx, req.TagTitle); errors.Is(err, pgx.ErrNoRows) {
+				return notFound("tag not found")
+			} else if err != nil {
+				slog.Error("get tag", "error", err)
+				return internalError()
+			}
 		}
 		return c.NoContent(204)
 	})
@@ -122,7 +319,7 @@ func (s *Server) removeTagFromPhotoHandler() echo.HandlerFunc {
 			TagTitle: req.TagTitle,
 		}); err != nil {
 			slog.Error("remove tag from photo", "error", err)
-			return c.String(500, "internal server error")
+			return internalError()
 		}
 		return c.NoContent(204)
 	})
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// keywords of trashed tags are skipped instead of tagging the photo with tags nobody can see
+func TestCreatePhotoTrashedKeyword(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	trashed, created := unique("trashed"), unique("keyword")
+	s.addTestTag(t, trashed, "")
+	s.expect(t, 204, http.MethodDelete, "/api/v1/tags/"+trashed, nil)
+	t.Cleanup(func() { s.Queries.DeleteTagPermanently(ctx, created) })
+
+	id, skipped, err := s.createPhoto(ctx, "", unique("https://example.com/keywords")+".jpg", "", map[string]string{"Keywords": trashed + ", " + created}, nil)
+	if err != nil {
+		t.Fatalf("create photo: %v", err)
+	}
+	t.Cleanup(func() { s.Conn.Exec(ctx, "DELETE FROM photos WHERE id = $1", id) })
+	if !slices.Equal(skipped, []string{trashed}) {
+		t.Errorf("skipped keywords %q, want %q", skipped, trashed)
+	}
+	var tags []string
+	if err := s.Conn.QueryRow(ctx, "SELECT ARRAY_AGG(tag_title) FROM photo_tags WHERE photo_id = $1", id).Scan(&tags); err != nil {
+		t.Fatalf("list photo tags: %v", err)
+	}
+	if !slices.Equal(tags, []string{created}) {
+		t.Errorf("photo has tags %q, want %q", tags, created)
+	}
+}
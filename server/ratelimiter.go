@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -60,13 +61,18 @@ func (r *RateLimiter) cleanup() {
 	}
 }
 
-func (r *RateLimiter) Start() *RateLimiter {
-	// cleanup every window size interval
+// Start cleans up expired requests every window until ctx is cancelled.
+func (r *RateLimiter) Start(ctx context.Context) *RateLimiter {
 	go func() {
 		ticker := time.NewTicker(r.WindowSize)
-		defer ticker.Stop() // this goroutine runs forever, but good practice in case of future changes
-		for range ticker.C {
-			r.cleanup()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.cleanup()
+			}
 		}
 	}()
 	return r
@@ -79,7 +85,7 @@ func (r *RateLimiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 			identifier = c.RealIP()
 		}
 		if !r.AllowRequest(identifier) {
-			return c.String(429, "too many requests")
+			return newAPIError(429, "rate_limited", "too many requests")
 		}
 		return next(c)
 	}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net/http"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/tiredkangaroo/ajiteshcc/env"
@@ -14,12 +18,85 @@ import (
 )
 
 type Server struct {
-	Conn    *pgx.Conn
+	Conn    *pgxpool.Pool
 	Queries *db.Queries
+
+	rateLimiters []*RateLimiter // limiters used by the router, cleaned up while the server runs
+	workers      sync.WaitGroup // background workers, waited for on shutdown
 }
 
-func (s *Server) Run() error {
+// ErrWorkersRunning is returned by Run if background workers are still running at the shutdown
+// timeout. they may still be recording job outcomes, so the DB pool must not be closed.
+var ErrWorkersRunning = errors.New("background workers didn't stop before the shutdown timeout")
+
+// Run serves the API on ADDR and starts the background workers until ctx is cancelled. then it
+// stops accepting requests, waits up to SHUTDOWN_TIMEOUT for in-flight requests and workers to
+// finish, and returns. the caller still owns (and closes) the DB pool and bucket, unless Run
+// returns ErrWorkersRunning.
+func (s *Server) Run(ctx context.Context) error {
+	e := s.Router()
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	for _, r := range s.rateLimiters {
+		r.Start(workerCtx)
+	}
+	s.startTrashPurger(workerCtx)
+	s.startLeaseReaper(workerCtx)
+	s.startJobWorkers(workerCtx, int(env.DefaultEnv.JOB_WORKERS))
+
+	errc := make(chan error, 1)
+	go func() { errc <- e.Start(env.DefaultEnv.ADDR) }()
+
+	select {
+	case err := <-errc:
+		// the server couldn't start (e.g. ADDR is in use)
+		stopWorkers()
+		s.workers.Wait()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", env.DefaultEnv.SHUTDOWN_TIMEOUT)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), env.DefaultEnv.SHUTDOWN_TIMEOUT)
+	defer cancel()
+	// in-flight requests finish first, since they may enqueue jobs
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shut down server, closing remaining connections", "error", err)
+		e.Close()
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server", "error", err)
+	}
+
+	// running jobs are interrupted and rescheduled rather than waited on
+	stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("background workers stopped")
+		return nil
+	case <-shutdownCtx.Done():
+		return ErrWorkersRunning
+	}
+}
+
+// rateLimiter registers r to be cleaned up while the server runs.
+func (s *Server) rateLimiter(r *RateLimiter) *RateLimiter {
+	s.rateLimiters = append(s.rateLimiters, r)
+	return r
+}
+
+// Router returns the API's routes, without starting anything in the background (so tests can serve
+// it with httptest).
+func (s *Server) Router() *echo.Echo {
 	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(middleware.RequestID())
 	if env.DefaultEnv.DEBUG {
 		slog.Info("running server in debug mode")
 		e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
@@ -31,7 +108,7 @@ func (s *Server) Run() error {
 		slog.Info("running server in production mode")
 	}
 
-	api := e.Group("/api/v1")
+	api := &apiGroup{Group: e.Group("/api/v1")} // records routes for the OpenAPI spec
 
 	allowedOrigins := strings.Split(env.DefaultEnv.CORS_ALLOWED_ORIGINS, ",")
 	api.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -43,34 +120,83 @@ func (s *Server) Run() error {
 		},
 		AllowCredentials: true,
 	}))
+	api.Use(s.ResolveTagMiddleware)       // tag routes take a slug or a title as :title
+	api.Use(UnescapeObjectNameMiddleware) // object routes take keys with slashes as :name (escaped as %2F)
 
 	// photos endpoints (/api/v1/photos)
-	api.GET("/photos", s.getAllPhotos)                                                          // list all photos (GET /api/v1/photos)
-	api.GET("/photos/:id", s.getPhotoByIDHandler())                                             // get photo by ID (GET /api/v1/photos/:id)
-	api.POST("/photos", s.addPhotoHandler(), RequireAdminMiddleware)                            // add photo (POST /api/v1/photos) - admin only
-	api.PATCH("/photos/:id/tag/:title", s.addTagToPhotoHandler(), RequireAdminMiddleware)       // add tag to photo (POST /api/v1/photos/tag) - admin only
-	api.DELETE("/photos/:id/tag/:title", s.removeTagFromPhotoHandler(), RequireAdminMiddleware) // remove tag from photo (DELETE /api/v1/photos/tag/:title) - admin only
+	api.GET("/photos", s.getAllPhotos)                                                                // list all photos (GET /api/v1/photos)
+	api.GET("/photos/stats", s.getPhotoStats)                                                         // camera, lens and exposure statistics (GET /api/v1/photos/stats)
+	api.GET("/photos/archive", s.getPhotoArchive)                                                     // photo counts by year, month and day taken (GET /api/v1/photos/archive)
+	api.GET("/photos/archive/:year/:month", s.getPhotoArchiveMonthHandler())                          // photos taken in a month, by day (GET /api/v1/photos/archive/:year/:month)
+	api.GET("/photos/:id", s.getPhotoByIDHandler())                                                   // get photo by ID (GET /api/v1/photos/:id)
+	api.POST("/photos", s.addPhotoHandler(), RequireAdminMiddleware)                                  // add photo (POST /api/v1/photos) - admin only
+	api.DELETE("/photos/:id", s.deletePhotoHandler(), RequireAdminMiddleware)                         // move photo to the trash (DELETE /api/v1/photos/:id) - admin only
+	api.PATCH("/photos/:id/metadata", s.updatePhotoMetadataHandler(), RequireAdminMiddleware)         // replace photo metadata and push it to the bucket object - admin only
+	api.POST("/photos/:id/metadata/extract", s.extractPhotoMetadataHandler(), RequireAdminMiddleware) // re-extract EXIF from the original - admin only
+	api.PATCH("/photos/:id/tag/:title", s.addTagToPhotoHandler(), RequireAdminMiddleware)             // add tag to photo (POST /api/v1/photos/tag) - admin only
+	api.DELETE("/photos/:id/tag/:title", s.removeTagFromPhotoHandler(), RequireAdminMiddleware)       // remove tag from photo (DELETE /api/v1/photos/tag/:title) - admin only
 
 	// bucket (photo storage) endpoints (/api/v1/bucket)
 	api.GET("/objects", s.listAllBucketPhotoObjects, RequireAdminMiddleware)             // list all bucket objects (GET /api/v1/bucket) - admin only
 	api.POST("/objects", s.uploadPhotoToBucketHandler(), RequireAdminMiddleware)         // upload photo to bucket (POST /api/v1/bucket) - admin only
 	api.PATCH("/objects/:name", s.updateObjectMetadataHandler(), RequireAdminMiddleware) // update object metadata (PATCH /api/v1/bucket/object) - admin only
+	api.DELETE("/objects/:name", s.deleteObjectHandler(), RequireAdminMiddleware)        // move object not used by any photo to the trash (DELETE /api/v1/objects/:name) - admin only
+	api.POST("/objects/:name/move", s.moveObjectHandler(), RequireAdminMiddleware)       // move/rename object and update photos using it (POST /api/v1/objects/:name/move) - admin only
+
+	// multipart uploads for large originals (/api/v1/objects/:name/multipart)
+	api.GET("/objects/:name/multipart", s.listMultipartUploadsHandler(), RequireAdminMiddleware)                // list in-progress uploads for an object - admin only
+	api.POST("/objects/:name/multipart", s.createMultipartUploadHandler(), RequireAdminMiddleware)              // start a multipart upload - admin only
+	api.GET("/objects/:name/multipart/:upload_id", s.listUploadedPartsHandler(), RequireAdminMiddleware)        // list uploaded parts and progress (used to resume) - admin only
+	api.PUT("/objects/:name/multipart/:upload_id/:part", s.uploadPartHandler, RequireAdminMiddleware)           // upload a single part (raw body) - admin only
+	api.POST("/objects/:name/multipart/:upload_id", s.completeMultipartUploadHandler(), RequireAdminMiddleware) // complete the upload - admin only
+	api.DELETE("/objects/:name/multipart/:upload_id", s.abortMultipartUploadHandler(), RequireAdminMiddleware)  // abort the upload - admin only
 
 	// posts endpoints (/api/v1/posts)
 	api.GET("/posts", s.listPosts, IsAdminMiddleware)                                           // list all posts (GET /api/v1/posts) -- admins see all, others see only published
 	api.GET("/posts/:slug", s.getPostBySlug, IsAdminMiddleware)                                 // get post by slug (GET /api/v1/posts/:slug) -- admins can see unpublished posts
 	api.POST("/posts", s.addPostHandler(), RequireAdminMiddleware)                              // add post (POST /api/v1/posts) - admin only
+	api.PATCH("/posts/:slug", s.updatePostHandler(), RequireAdminMiddleware)                    // edit or (un)publish post (PATCH /api/v1/posts/:slug) - admin only
+	api.DELETE("/posts/:slug", s.deletePostHandler(), RequireAdminMiddleware)                   // move post to the trash (DELETE /api/v1/posts/:slug) - admin only
 	api.PATCH("/posts/:slug/tag/:title", s.addTagToPostHandler(), RequireAdminMiddleware)       // add tag to post (POST /api/v1/posts/tag) - admin only
 	api.DELETE("/posts/:slug/tag/:title", s.removeTagFromPostHandler(), RequireAdminMiddleware) // remove tag from post (DELETE /api/v1/posts/tag/:title) - admin only
 
 	// tags endpoints (/api/v1/tags)
-	api.GET("/tags", s.listTags)                                    // list all tags (GET /api/v1/tags)
-	api.POST("/tags", s.addTagHandler(), RequireAdminMiddleware)    // add tag (POST /api/v1/tags) - admin only
-	api.DELETE("/tags/:title", s.deleteTag, RequireAdminMiddleware) // delete tag (DELETE /api/v1/tags) - admin only
+	api.GET("/tags", s.listTags)                                                // list all tags, nested with ?tree=true or counted with ?with_counts=true (GET /api/v1/tags)
+	api.POST("/tags", s.addTagHandler(), RequireAdminMiddleware)                // add tag (POST /api/v1/tags) - admin only
+	api.GET("/tags/suggest", s.suggestTagsHandler(), RequireAdminMiddleware)    // tag autocomplete (?q=) or tags proposed for a photo (?photo_id=) - admin only
+	api.POST("/tags/bulk", s.bulkTagHandler(), RequireAdminMiddleware)          // add and remove tags on many photos and posts at once - admin only
+	api.GET("/tags/:title", s.getTagHandler())                                  // tag with photo and post counts and a page of each (GET /api/v1/tags/:title)
+	api.DELETE("/tags/:title", s.deleteTag, RequireAdminMiddleware)             // move tag to the trash (DELETE /api/v1/tags) - admin only
+	api.PATCH("/tags/:title", s.updateTagHandler(), RequireAdminMiddleware)     // rename tag or edit its comment (PATCH /api/v1/tags/:title) - admin only
+	api.POST("/tags/:title/merge", s.mergeTagHandler(), RequireAdminMiddleware) // merge tag into another (POST /api/v1/tags/:title/merge) - admin only
+	api.GET("/tags/:title/photos", s.getTagPhotosHandler())                     // photos tagged with the tag or its descendants (GET /api/v1/tags/:title/photos)
+	api.GET("/tags/:title/posts", s.getTagPostsHandler(), IsAdminMiddleware)    // posts tagged with the tag or its descendants -- admins can see unpublished posts
+
+	// bulk import endpoints (/api/v1/imports)
+	api.GET("/imports", s.listImports, RequireAdminMiddleware)                       // list imports - admin only
+	api.POST("/imports", s.createImportHandler(), RequireAdminMiddleware)            // import every photo under a bucket prefix or in a local directory - admin only
+	api.GET("/imports/:id", s.getImportHandler(), RequireAdminMiddleware)            // import progress and per-file results - admin only
+	api.POST("/imports/:id/resume", s.resumeImportHandler(), RequireAdminMiddleware) // resume an interrupted import and retry failed files - admin only
 
 	// admin endpoints (/api/v1/admin)
-	api.GET("/admin", s.isAdmin)                                                                   // check if admin (GET /api/v1/admin)
-	api.POST("/admin", s.adminLoginHandler(), NewRateLimiter(5, 10*time.Minute, false).Middleware) // admin login (POST /api/v1/admin) - uses global 5 requests per 10 minutes rate limiter
+	api.GET("/admin", s.isAdmin)                                                                                  // check if admin (GET /api/v1/admin)
+	api.POST("/admin", s.adminLoginHandler(), s.rateLimiter(NewRateLimiter(5, 10*time.Minute, false)).Middleware) // admin login (POST /api/v1/admin) - uses global 5 requests per 10 minutes rate limiter
+
+	// job queue endpoints (/api/v1/admin/jobs)
+	api.GET("/admin/jobs", s.listJobsHandler(), RequireAdminMiddleware)            // list jobs, optionally by status - admin only
+	api.GET("/admin/jobs/:id", s.getJobHandler(), RequireAdminMiddleware)          // get job - admin only
+	api.POST("/admin/jobs/:id/retry", s.retryJobHandler(), RequireAdminMiddleware) // retry failed job - admin only
+
+	// trash endpoints (/api/v1/admin/trash)
+	api.GET("/admin/trash", s.listTrash, RequireAdminMiddleware)                                     // list trashed photos, posts, tags and objects - admin only
+	api.POST("/admin/trash/photos/:id/restore", s.restorePhotoHandler(), RequireAdminMiddleware)     // restore trashed photo - admin only
+	api.POST("/admin/trash/posts/:slug/restore", s.restorePostHandler(), RequireAdminMiddleware)     // restore trashed post - admin only
+	api.POST("/admin/trash/tags/:title/restore", s.restoreTagHandler(), RequireAdminMiddleware)      // restore trashed tag - admin only
+	api.POST("/admin/trash/objects/:name/restore", s.restoreObjectHandler(), RequireAdminMiddleware) // restore trashed object - admin only
+
+	// API docs, generated from the routes above (/api/v1/openapi.json and /api/v1/docs)
+	api.Group.GET("/openapi.json", openAPIHandler(api.openAPISpec())) // OpenAPI 3 spec (GET /api/v1/openapi.json)
+	api.Group.GET("/docs", docsHandler)                               // docs UI for the spec (GET /api/v1/docs)
 
-	return e.Start(env.DefaultEnv.ADDR)
+	return e
 }
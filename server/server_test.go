@@ -0,0 +1,102 @@
+package server
+
+// tests that need the database serve the API in-process against POSTGRES_CONNECTION_URI, which
+// must have schema.sql applied. they're skipped without the server's environment (see env/env.go)
+// or if the database can't be reached.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+type testServer struct {
+	*Server
+	router *echo.Echo
+	token  string // admin JWT
+}
+
+// newTestServer returns a server backed by the database, skipping the test if it's unavailable.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if env.Err != nil {
+		t.Skipf("environment unavailable: %v", env.Err)
+	}
+	pool, err := pgxpool.New(context.Background(), env.DefaultEnv.POSTGRES_CONNECTION_URI) // connects lazily
+	if err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := pool.Ping(ctx); err != nil {
+		t.Skipf("database unavailable: %v", err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
+		Subject:   "administrator",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(env.DefaultEnv.JWT_SECRET)
+	if err != nil {
+		t.Fatalf("sign admin token: %v", err)
+	}
+	s := &Server{Conn: pool, Queries: db.New(pool)}
+	return &testServer{Server: s, router: s.Router(), token: token}
+}
+
+// do serves a request as an admin, with body (if not nil) encoded as JSON.
+func (s *testServer) do(t *testing.T, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.AddCookie(&http.Cookie{Name: "admin_token", Value: s.token})
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect serves a request like do and fails the test unless it responds with status.
+func (s *testServer) expect(t *testing.T, status int, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := s.do(t, method, path, body)
+	if rec.Code != status {
+		t.Fatalf("%s %s: got %d %s, want %d", method, path, rec.Code, rec.Body, status)
+	}
+	return rec
+}
+
+// unique returns name with a suffix that's different every time.
+func unique(name string) string {
+	return name + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// addTestTag adds a tag (under parent, unless it's empty) that's deleted for good when the test
+// ends.
+func (s *testServer) addTestTag(t *testing.T, title, parent string) {
+	t.Helper()
+	s.expect(t, 204, http.MethodPost, "/api/v1/tags", echo.Map{"title": title, "parent": parent})
+	t.Cleanup(func() { s.Queries.DeleteTagPermanently(context.Background(), title) })
+}
+
+// addTestPost adds a published post with tags that's deleted for good when the test ends.
+func (s *testServer) addTestPost(t *testing.T, slug string, tags ...string) {
+	t.Helper()
+	s.expect(t, 201, http.MethodPost, "/api/v1/posts", echo.Map{"slug": slug, "published": true, "content": "test", "tags": tags})
+	t.Cleanup(func() { s.Conn.Exec(context.Background(), "DELETE FROM posts WHERE slug = $1", slug) })
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// how long photo stats are cached. stats only move when photos are added, so they're allowed to be
+// a little stale rather than running seven aggregations on every request.
+const photoStatsTTL = 5 * time.Minute
+
+// focalLengthBins are the ranges (in mm, inclusive) of the focal length histogram. they follow
+// the usual lens categories, from ultra wide to super telephoto.
+var focalLengthBins = []struct{ Min, Max int32 }{
+	{0, 14}, {15, 24}, {25, 35}, {36, 50}, {51, 85}, {86, 135}, {136, 200}, {201, 400}, {401, 0},
+}
+
+type focalLengthBin struct {
+	Label string `json:"label"` // e.g. "25-35mm" or "401mm+"
+	Min   int32  `json:"min"`
+	Max   int32  `json:"max"` // 0 for the last, open-ended bin
+	Count int64  `json:"count"`
+}
+
+type photoStats struct {
+	Total                int64                            `json:"total"`                  // number of photos
+	Cameras              []db.CountPhotosByCameraRow      `json:"cameras"`                // photos per camera, most used first
+	Lenses               []db.CountPhotosByLensRow        `json:"lenses"`                 // photos per lens, most used first
+	FocalLengths         []db.CountPhotosByFocalLengthRow `json:"focal_lengths"`          // photos per focal length (rounded to the mm)
+	FocalLengthHistogram []focalLengthBin                 `json:"focal_length_histogram"` // photos per focal length range
+	Apertures            []db.CountPhotosByApertureRow    `json:"apertures"`              // photos per f-number (rounded to 0.1)
+	ISOs                 []db.CountPhotosByISORow         `json:"isos"`                   // photos per ISO
+	Months               []db.CountPhotosByMonthRow       `json:"months"`                 // photos per month taken ("2006-01")
+	GeneratedAt          time.Time                        `json:"generated_at"`
+}
+
+var photoStatsCache struct {
+	mx    sync.Mutex
+	stats *photoStats
+}
+
+// GET /api/v1/photos/stats
+func (s *Server) getPhotoStats(c echo.Context) error {
+	stats, err := s.cachedPhotoStats(c.Request().Context())
+	if err != nil {
+		slog.Error("compute photo stats", "error", err)
+		return internalError()
+	}
+	maxAge := photoStatsTTL - time.Since(stats.GeneratedAt)
+	c.Response().Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(max(maxAge, 0).Seconds())))
+	return c.JSON(200, stats)
+}
+
+// cachedPhotoStats returns the cached stats, recomputing them if they're older than photoStatsTTL.
+// the lock is held while computing so concurrent requests don't all hit the database.
+func (s *Server) cachedPhotoStats(ctx context.Context) (*photoStats, error) {
+	photoStatsCache.mx.Lock()
+	defer photoStatsCache.mx.Unlock()
+	if cached := photoStatsCache.stats; cached != nil && time.Since(cached.GeneratedAt) < photoStatsTTL {
+		return cached, nil
+	}
+	stats, err := s.computePhotoStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	photoStatsCache.stats = stats
+	return stats, nil
+}
+
+func (s *Server) computePhotoStats(ctx context.Context) (*photoStats, error) {
+	var stats photoStats
+	var err error
+	if stats.Total, err = s.Queries.CountPhotos(ctx); err != nil {
+		return nil, fmt.Errorf("count photos: %w", err)
+	}
+	if stats.Cameras, err = s.Queries.CountPhotosByCamera(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by camera: %w", err)
+	}
+	if stats.Lenses, err = s.Queries.CountPhotosByLens(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by lens: %w", err)
+	}
+	if stats.FocalLengths, err = s.Queries.CountPhotosByFocalLength(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by focal length: %w", err)
+	}
+	if stats.Apertures, err = s.Queries.CountPhotosByAperture(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by aperture: %w", err)
+	}
+	if stats.ISOs, err = s.Queries.CountPhotosByISO(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by iso: %w", err)
+	}
+	if stats.Months, err = s.Queries.CountPhotosByMonth(ctx); err != nil {
+		return nil, fmt.Errorf("count photos by month: %w", err)
+	}
+	stats.FocalLengthHistogram = focalLengthHistogram(stats.FocalLengths)
+	stats.GeneratedAt = time.Now()
+	return &stats, nil
+}
+
+func focalLengthHistogram(counts []db.CountPhotosByFocalLengthRow) []focalLengthBin {
+	bins := make([]focalLengthBin, len(focalLengthBins))
+	for i, b := range focalLengthBins {
+		bins[i] = focalLengthBin{Label: fmt.Sprintf("%d-%dmm", b.Min, b.Max), Min: b.Min, Max: b.Max}
+		if b.Max == 0 {
+			bins[i].Label = fmt.Sprintf("%dmm+", b.Min)
+		}
+	}
+	for _, row := range counts {
+		for i, b := range focalLengthBins {
+			if row.FocalLength >= b.Min && (b.Max == 0 || row.FocalLength <= b.Max) {
+				bins[i].Count += row.Count
+				break
+			}
+		}
+	}
+	return bins
+}
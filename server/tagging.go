@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// maximum number of photos and posts in a bulk tagging request
+const maxBulkTagItems = 1000
+
+type bulkTagResult struct {
+	PhotoID   int32    `json:"photo_id,omitempty"`
+	PostSlug  string   `json:"post_slug,omitempty"`
+	Added     []string `json:"added"`           // tags added
+	Removed   []string `json:"removed"`         // tags removed
+	Unchanged []string `json:"unchanged"`       // tags it already had (add) or didn't have (remove)
+	Error     string   `json:"error,omitempty"` // e.g. "photo not found"; nothing was changed for the item
+}
+
+// POST /api/v1/tags/bulk
+//
+// adds and removes tags on many photos and posts in one transaction. adding a tag an item already
+// has (or removing one it doesn't) is not an error, so requests can be safely retried. items that
+// don't exist are reported in their result without failing the rest. adding tags that are in the
+// trash fails with a 409, with or without create_missing.
+func (s *Server) bulkTagHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		PhotoIDs      []int32  `json:"photo_ids" required:"false"`
+		PostSlugs     []string `json:"post_slugs" required:"false"`
+		Add           []string `json:"add" required:"false"`    // slugs or titles of tags to add
+		Remove        []string `json:"remove" required:"false"` // slugs or titles of tags to remove
+		CreateMissing bool     `json:"create_missing"`          // create tags in add that don't exist (instead of failing)
+	}) error {
+		ctx := c.Request().Context()
+		if len(req.PhotoIDs)+len(req.PostSlugs) == 0 {
+			return badRequest("no photo_ids or post_slugs")
+		} else if len(req.PhotoIDs)+len(req.PostSlugs) > maxBulkTagItems {
+			return badRequest("at most 1000 photos and posts can be tagged at once")
+		} else if len(req.Add)+len(req.Remove) == 0 {
+			return badRequest("no tags to add or remove")
+		}
+		add, err := s.resolveTagTitles(ctx, req.Add)
+		if err != nil {
+			slog.Error("resolve tags", "error", err)
+			return internalError()
+		}
+		remove, err := s.resolveTagTitles(ctx, req.Remove)
+		if err != nil {
+			slog.Error("resolve tags", "error", err)
+			return internalError()
+		}
+		for _, title := range add {
+			if slices.Contains(remove, title) {
+				return badRequest("tag " + title + " is both added and removed")
+			}
+		}
+
+		tx, err := s.Conn.Begin(ctx)
+		if err != nil {
+			slog.Error("begin transaction", "error", err)
+			return internalError()
+		}
+		defer tx.Rollback(ctx)
+		queries := s.Queries.WithTx(tx)
+
+		created := []string{}
+		if len(add) > 0 {
+			missing, err := missingTags(ctx, queries, add)
+			if err != nil {
+				slog.Error("list existing tags", "error", err)
+				return internalError()
+			}
+			// trashed tags are never created over (that would lose their photos and posts), and
+			// adding them would make invisible associations
+			if len(missing) > 0 {
+				trashed, err := queries.ListTrashedTagTitles(ctx, missing)
+				if err != nil {
+					slog.Error("list trashed tags", "error", err)
+					return internalError()
+				} else if len(trashed) > 0 {
+					return trashedTagsConflict(trashed)
+				}
+			}
+			if len(missing) > 0 && req.CreateMissing {
+				if err := queries.CreateTagsIfNotExist(ctx, missing); err != nil {
+					slog.Error("create tags", "error", err)
+					return internalError()
+				}
+				created, missing = missing, nil
+			}
+			if len(missing) > 0 {
+				return notFound("tags not found: " + strings.Join(missing, ", "))
+			}
+		}
+
+		photoIDs, err := queries.ListExistingPhotoIDs(ctx, req.PhotoIDs)
+		if err != nil {
+			slog.Error("list existing photos", "error", err)
+			return internalError()
+		}
+		postSlugs, err := queries.ListExistingPostSlugs(ctx, req.PostSlugs)
+		if err != nil {
+			slog.Error("list existing posts", "error", err)
+			return internalError()
+		}
+
+		results := make([]bulkTagResult, 0, len(req.PhotoIDs)+len(req.PostSlugs))
+		for _, id := range req.PhotoIDs {
+			result := bulkTagResult{PhotoID: id}
+			if !slices.Contains(photoIDs, id) {
+				result.Error = "photo not found"
+				results = append(results, result.withUnchanged(nil, nil))
+				continue
+			}
+			if result.Added, err = queries.AddMissingTagsToPhoto(ctx, db.AddMissingTagsToPhotoParams{PhotoID: id, TagTitles: add}); err != nil {
+				slog.Error("add tags to photo", "error", err)
+				return internalError()
+			}
+			if result.Removed, err = queries.RemoveTagsFromPhoto(ctx, db.RemoveTagsFromPhotoParams{PhotoID: id, TagTitles: remove}); err != nil {
+				slog.Error("remove tags from photo", "error", err)
+				return internalError()
+			}
+			results = append(results, result.withUnchanged(add, remove))
+		}
+		for _, slug := range req.PostSlugs {
+			result := bulkTagResult{PostSlug: slug}
+			if !slices.Contains(postSlugs, slug) {
+				result.Error = "post not found"
+				results = append(results, result.withUnchanged(nil, nil))
+				continue
+			}
+			if result.Added, err = queries.AddMissingTagsToPost(ctx, db.AddMissingTagsToPostParams{PostSlug: slug, TagTitles: add}); err != nil {
+				slog.Error("add tags to post", "error", err)
+				return internalError()
+			}
+			if result.Removed, err = queries.RemoveTagsFromPost(ctx, db.RemoveTagsFromPostParams{PostSlug: slug, TagTitles: remove}); err != nil {
+				slog.Error("remove tags from post", "error", err)
+				return internalError()
+			}
+			results = append(results, result.withUnchanged(add, remove))
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("commit transaction", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, echo.Map{
+			"created_tags": created,
+			"results":      results,
+		})
+	})
+}
+
+// withUnchanged fills in the tags that were requested but not added or removed.
+func (r bulkTagResult) withUnchanged(add, remove []string) bulkTagResult {
+	r.Added = append([]string{}, r.Added...) // never null in JSON
+	r.Removed = append([]string{}, r.Removed...)
+	r.Unchanged = []string{}
+	for _, title := range add {
+		if !slices.Contains(r.Added, title) {
+			r.Unchanged = append(r.Unchanged, title)
+		}
+	}
+	for _, title := range remove {
+		if !slices.Contains(r.Removed, title) {
+			r.Unchanged = append(r.Unchanged, title)
+		}
+	}
+	return r
+}
+
+// resolveTagTitles resolves slugs or titles to titles (see resolveTagTitle), dropping duplicates.
+func (s *Server) resolveTagTitles(ctx context.Context, names []string) ([]string, error) {
+	titles := make([]string, 0, len(names))
+	for _, name := range names {
+		title, err := s.resolveTagTitle(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if title != "" && !slices.Contains(titles, title) {
+			titles = append(titles, title)
+		}
+	}
+	return titles, nil
+}
+
+// missingTags returns the titles that aren't tags (or are in the trash).
+func missingTags(ctx context.Context, queries *db.Queries, titles []string) ([]string, error) {
+	existing, err := queries.ListExistingTagTitles(ctx, titles)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, title := range titles {
+		if !slices.Contains(existing, title) {
+			missing = append(missing, title)
+		}
+	}
+	return missing, nil
+}
@@ -2,45 +2,586 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"github.com/tiredkangaroo/ajiteshcc/gen/db"
 )
 
 // GET /api/v1/tags
+//
+// with ?tree=true, tags are nested under their parents. with ?with_counts=true, every tag has the
+// number of photos and published posts tagged with it.
 func (s *Server) listTags(c echo.Context) error {
-	data, err := s.Queries.ListTags(c.Request().Context())
+	if c.QueryParam("with_counts") == "true" {
+		return s.listTagsWithCounts(c)
+	}
+	if c.QueryParam("tree") != "true" {
+		data, err := s.Queries.ListTags(c.Request().Context())
+		if err != nil {
+			slog.Error("list tags", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, data)
+	}
+	tags, err := s.Queries.ListTagsWithParents(c.Request().Context())
+	if err != nil {
+		slog.Error("list tags with parents", "error", err)
+		return internalError()
+	}
+	return c.JSON(200, tagTree(tags))
+}
+
+type tagWithCounts struct {
+	Title      string      `json:"title"`
+	Comment    pgtype.Text `json:"comment"`
+	Slug       string      `json:"slug"`
+	PhotoCount int64       `json:"photo_count"`
+	PostCount  int64       `json:"post_count"` // published posts only
+}
+
+func (s *Server) listTagsWithCounts(c echo.Context) error {
+	ctx := c.Request().Context()
+	photoCounts, err := s.Queries.ListTagsWithPhotosCount(ctx)
+	if err != nil {
+		slog.Error("list tags with photos count", "error", err)
+		return internalError()
+	}
+	postCounts, err := s.Queries.ListTagsWithPostsCount(ctx)
 	if err != nil {
-		slog.Error("list tags", "error", err)
-		return c.String(500, "internal server error")
+		slog.Error("list tags with posts count", "error", err)
+		return internalError()
+	}
+	postCountByTitle := make(map[string]int64, len(postCounts))
+	for _, row := range postCounts {
+		postCountByTitle[row.Title] = row.PostCount
+	}
+	tags := make([]tagWithCounts, len(photoCounts))
+	for i, row := range photoCounts {
+		tags[i] = tagWithCounts{
+			Title:      row.Title,
+			Comment:    row.Comment,
+			Slug:       row.Slug,
+			PhotoCount: row.PhotoCount,
+			PostCount:  postCountByTitle[row.Title],
+		}
+	}
+	return c.JSON(200, tags)
+}
+
+// GET /api/v1/tags/:title
+//
+// the tag with its photo and published post counts, and a page of each. pages hold per_page photos
+// and per_page posts.
+func (s *Server) getTagHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title   string `param:"title"`
+		Page    int32  `query:"page"`     // page number, starting at 1 (default 1)
+		PerPage int32  `query:"per_page"` // photos and posts per page (default 20, at most 100)
+	}) error {
+		ctx := c.Request().Context()
+		req.Page = max(req.Page, 1)
+		if req.PerPage <= 0 {
+			req.PerPage = 20
+		}
+		req.PerPage = min(req.PerPage, 100)
+		offset := (req.Page - 1) * req.PerPage
+
+		tag, err := s.Queries.GetTag(ctx, req.Title)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("tag not found")
+		} else if err != nil {
+			slog.Error("get tag", "error", err)
+			return internalError()
+		}
+		photoCount, err := s.Queries.CountPhotosWithTag(ctx, tag.Title)
+		if err != nil {
+			slog.Error("count photos with tag", "error", err)
+			return internalError()
+		}
+		postCount, err := s.Queries.CountPublishedPostsWithTag(ctx, tag.Title)
+		if err != nil {
+			slog.Error("count posts with tag", "error", err)
+			return internalError()
+		}
+		photos, err := s.Queries.ListPhotosWithTagPage(ctx, db.ListPhotosWithTagPageParams{
+			TagTitle:  tag.Title,
+			RowLimit:  req.PerPage,
+			RowOffset: offset,
+		})
+		if err != nil {
+			slog.Error("list photos with tag", "error", err)
+			return internalError()
+		}
+		posts, err := s.Queries.ListPublishedPostsWithTagPage(ctx, db.ListPublishedPostsWithTagPageParams{
+			TagTitle:  tag.Title,
+			RowLimit:  req.PerPage,
+			RowOffset: offset,
+		})
+		if err != nil {
+			slog.Error("list posts with tag", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, echo.Map{
+			"tag":         tag,
+			"photo_count": photoCount,
+			"post_count":  postCount,
+			"photos":      photos,
+			"posts":       posts,
+			"page":        req.Page,
+			"per_page":    req.PerPage,
+		})
+	})
+}
+
+type tagNode struct {
+	Title    string      `json:"title"`
+	Comment  pgtype.Text `json:"comment"`
+	Slug     string      `json:"slug"`
+	Children []*tagNode  `json:"children"`
+}
+
+// tagTree nests tags under their parents. tags whose parent is missing (e.g. in the trash) are
+// roots. tags are sorted by title, so every level is too.
+//
+// cycles are prevented when tags are moved, but two concurrent moves can still make one. the tag
+// that would close a cycle is made a root instead, so no tag goes missing from the tree.
+func tagTree(tags []db.ListTagsWithParentsRow) []*tagNode {
+	nodes := make(map[string]*tagNode, len(tags))
+	for _, tag := range tags {
+		nodes[tag.Title] = &tagNode{Title: tag.Title, Comment: tag.Comment, Slug: tag.Slug, Children: []*tagNode{}}
 	}
-	return c.JSON(200, data)
+	parents := make(map[string]string, len(tags)) // parent of every tag nested so far
+	isAncestor := func(title, of string) bool {
+		for t, ok := of, true; ok; t, ok = parents[t] {
+			if t == title {
+				return true
+			}
+		}
+		return false
+	}
+	roots := []*tagNode{}
+	for _, tag := range tags {
+		parent, ok := nodes[tag.ParentTitle.String]
+		if tag.ParentTitle.Valid && ok && !isAncestor(tag.Title, parent.Title) {
+			parent.Children = append(parent.Children, nodes[tag.Title])
+			parents[tag.Title] = parent.Title
+		} else {
+			roots = append(roots, nodes[tag.Title])
+		}
+	}
+	return roots
 }
 
 // POST /api/v1/tags
+//
+// fails with a 409 if a tag with the same title is in the trash, so its photos and posts aren't
+// lost; restore it instead.
 func (s *Server) addTagHandler() echo.HandlerFunc {
 	return handler(func(c echo.Context, req struct {
 		Title   string `json:"title"`
 		Comment string `json:"comment" required:"false"`
+		Parent  string `json:"parent" required:"false"` // slug or title of the parent tag
 	}) error {
-		if err := s.Queries.CreateTag(context.Background(), db.CreateTagParams{
+		params := db.CreateTagParams{
 			Title:   req.Title,
 			Comment: pgText(req.Comment),
-		}); err != nil {
-			slog.Error("create tag", "error", err)
-			return c.String(500, "internal server error")
+		}
+		if req.Parent != "" {
+			parent, err := s.resolveTagTitle(c.Request().Context(), req.Parent)
+			if err != nil {
+				slog.Error("resolve parent tag", "error", err)
+				return internalError()
+			}
+			if _, err := s.Queries.GetTag(c.Request().Context(), parent); errors.Is(err, pgx.ErrNoRows) {
+				return notFound("parent tag not found")
+			} else if err != nil {
+				slog.Error("get parent tag", "error", err)
+				return internalError()
+			}
+			params.ParentTitle = pgText(parent)
+		}
+		if trashed, err := s.Queries.ListTrashedTagTitles(c.Request().Context(), []string{req.Title}); err != nil {
+			slog.Error("list trashed tags", "error", err)
+			return internalError()
+		} else if len(trashed) > 0 {
+			return trashedTagsConflict(trashed)
+		}
+		if err := s.Queries.CreateTag(context.Background(), params); err != nil {
+			return dbError(err, "create tag")
 		}
 		return c.NoContent(204)
 	})
 }
 
+// trashedTagsConflict is returned when trashed tags would be created over (losing their photos and
+// posts) or added to something.
+func trashedTagsConflict(titles []string) *APIError {
+	return conflict("tags are in the trash (restore them with POST /api/v1/admin/trash/tags/:title/restore): " + strings.Join(titles, ", "))
+}
+
+// GET /api/v1/tags/:title/photos
+//
+// photos tagged with the tag or any of its descendants.
+func (s *Server) getTagPhotosHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title string `param:"title"`
+	}) error {
+		photos, err := s.Queries.GetPhotosByTagTree(c.Request().Context(), req.Title)
+		if err != nil {
+			slog.Error("get photos by tag tree", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, photos)
+	})
+}
+
+// GET /api/v1/tags/:title/posts
+//
+// posts tagged with the tag or any of its descendants. only admins see unpublished posts.
+func (s *Server) getTagPostsHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title string `param:"title"`
+	}) error {
+		posts, err := s.Queries.GetPostsByTagTree(c.Request().Context(), db.GetPostsByTagTreeParams{
+			TagTitle:           req.Title,
+			IncludeUnpublished: c.Get("is_admin").(bool),
+		})
+		if err != nil {
+			slog.Error("get posts by tag tree", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, posts)
+	})
+}
+
 // DELETE /api/v1/tags/:title
+//
+// the tag is moved to the trash; its photo and post associations are kept so it can be restored.
 func (s *Server) deleteTag(c echo.Context) error {
 	title := c.Param("title")
-	if err := s.Queries.DeleteTag(context.Background(), title); err != nil {
+	n, err := s.Queries.DeleteTag(context.Background(), title)
+	if err != nil {
 		slog.Error("delete tag by title", "error", err)
-		return c.String(500, "internal server error")
+		return internalError()
+	} else if n == 0 {
+		return notFound("tag not found")
 	}
 	return c.NoContent(204)
 }
+
+// PATCH /api/v1/tags/:title
+//
+// renames a tag, changes its slug, edits its comment and/or moves it under another tag. photos,
+// posts and child tags keep the tag through a rename.
+func (s *Server) updateTagHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title    string  `param:"title"`
+		NewTitle string  `json:"title" required:"false"`              // new title (unchanged if empty)
+		Comment  *string `json:"comment" required:"false"`            // new comment (unchanged if missing, removed if empty)
+		Parent   *string `json:"parent" required:"false"`             // slug or title of the new parent (unchanged if missing, removed if empty)
+		Slug     string  `json:"slug" required:"false" format:"slug"` // new slug (regenerated from the new title if empty)
+	}) error {
+		params := db.UpdateTagParams{
+			Title:    req.Title,
+			NewTitle: req.Title,
+		}
+		if req.NewTitle != "" {
+			params.NewTitle = req.NewTitle
+		}
+		if req.Comment != nil {
+			params.SetComment = true
+			params.Comment = pgtype.Text{String: *req.Comment, Valid: *req.Comment != ""}
+		}
+		if req.Slug != "" {
+			params.Slug = pgText(req.Slug)
+		}
+
+		ctx := c.Request().Context()
+		tx, err := s.Conn.Begin(ctx)
+		if err != nil {
+			slog.Error("begin transaction", "error", err)
+			return internalError()
+		}
+		defer tx.Rollback(ctx)
+		queries := s.Queries.WithTx(tx)
+
+		tag, err := queries.UpdateTag(ctx, params)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("tag not found")
+		} else if isUniqueViolation(err) {
+			return conflict("a tag with that title or slug already exists (it may be in the trash)")
+		} else if err != nil {
+			slog.Error("update tag", "error", err)
+			return internalError()
+		}
+		if req.Parent != nil {
+			parent := pgtype.Text{String: *req.Parent, Valid: *req.Parent != ""}
+			if parent.Valid {
+				if parent.String, err = s.resolveTagTitle(ctx, parent.String); err != nil {
+					slog.Error("resolve parent tag", "error", err)
+					return internalError()
+				}
+				if _, err := queries.GetTag(ctx, parent.String); errors.Is(err, pgx.ErrNoRows) {
+					return notFound("parent tag not found")
+				} else if err != nil {
+					slog.Error("get parent tag", "error", err)
+					return internalError()
+				}
+				cycle, err := queries.IsTagDescendant(ctx, db.IsTagDescendantParams{
+					Title:     tag.Title,
+					Candidate: parent.String,
+				})
+				if err != nil {
+					slog.Error("check tag descendants", "error", err)
+					return internalError()
+				} else if cycle {
+					return conflict("a tag can't be moved under itself or one of its descendants")
+				}
+			}
+			if _, err := queries.SetTagParent(ctx, db.SetTagParentParams{
+				Title:       tag.Title,
+				ParentTitle: parent,
+			}); err != nil {
+				slog.Error("set tag parent", "error", err)
+				return internalError()
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("commit transaction", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, tag)
+	})
+}
+
+// POST /api/v1/tags/:title/merge
+//
+// moves every photo and post tagged with :title, and every child tag of :title, to the tag "into",
+// then deletes :title.
+func (s *Server) mergeTagHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title string `param:"title"`
+		Into  string `json:"into"` // slug or title of the tag that's kept
+	}) error {
+		into, err := s.resolveTagTitle(c.Request().Context(), req.Into)
+		if err != nil {
+			slog.Error("resolve tag", "error", err)
+			return internalError()
+		}
+		req.Into = into
+		if req.Title == req.Into {
+			return badRequest("can't merge a tag into itself")
+		}
+		ctx := c.Request().Context()
+		tx, err := s.Conn.Begin(ctx)
+		if err != nil {
+			slog.Error("begin transaction", "error", err)
+			return internalError()
+		}
+		defer tx.Rollback(ctx)
+		queries := s.Queries.WithTx(tx)
+
+		for _, title := range []string{req.Title, req.Into} {
+			if _, err := queries.GetTag(ctx, title); errors.Is(err, pgx.ErrNoRows) {
+				return notFound("tag " + title + " not found")
+			} else if err != nil {
+				slog.Error("get tag", "error", err)
+				return internalError()
+			}
+		}
+		// the kept tag takes the merged one's children, which can't include the kept tag itself
+		cycle, err := queries.IsTagDescendant(ctx, db.IsTagDescendantParams{
+			Title:     req.Title,
+			Candidate: req.Into,
+		})
+		if err != nil {
+			slog.Error("check tag descendants", "error", err)
+			return internalError()
+		} else if cycle {
+			return conflict("a tag can't be merged into one of its descendants")
+		}
+		merge := db.MergePhotoTagsParams{IntoTitle: req.Into, FromTitle: req.Title}
+		if err := queries.MergePhotoTags(ctx, merge); err != nil {
+			slog.Error("merge photo tags", "error", err)
+			return internalError()
+		}
+		if err := queries.MergePostTags(ctx, db.MergePostTagsParams(merge)); err != nil {
+			slog.Error("merge post tags", "error", err)
+			return internalError()
+		}
+		// the merged tag's children move under the kept tag, instead of becoming roots when it's deleted
+		if _, err := queries.ReparentTagChildren(ctx, db.ReparentTagChildrenParams{
+			NewParentTitle: pgtype.Text{String: req.Into, Valid: true},
+			ParentTitle:    pgtype.Text{String: req.Title, Valid: true},
+		}); err != nil {
+			slog.Error("reparent merged tag children", "error", err)
+			return internalError()
+		}
+		if _, err := queries.DeleteTagPermanently(ctx, req.Title); err != nil {
+			slog.Error("delete merged tag", "error", err)
+			return internalError()
+		}
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("commit transaction", "error", err)
+			return internalError()
+		}
+		return c.NoContent(204)
+	})
+}
+
+type tagSuggestion struct {
+	Title   string      `json:"title"`
+	Comment pgtype.Text `json:"comment"`
+	Slug    string      `json:"slug"`            // empty for new tags
+	New     bool        `json:"new"`             // suggested from the photo's keywords; the tag doesn't exist yet
+	Source  string      `json:"source"`          // "keywords" or "similar_photos"
+	Score   float64     `json:"score,omitempty"` // similar_photos only; higher is better
+}
+
+// GET /api/v1/tags/suggest
+//
+// tag autocomplete: tags matching q by prefix or trigram similarity, most used first. with
+// photo_id, proposes tags for that photo instead: its EXIF/IPTC/XMP keywords followed by the tags
+// of similar photos.
+func (s *Server) suggestTagsHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Query   string `query:"q" required:"false"` // what's been typed so far
+		PhotoID int32  `query:"photo_id"`           // propose tags for this photo instead
+		Limit   int32  `query:"limit"`              // maximum number of suggestions (default 10, at most 50)
+	}) error {
+		ctx := c.Request().Context()
+		if req.Limit <= 0 {
+			req.Limit = 10
+		}
+		req.Limit = min(req.Limit, 50)
+		if req.PhotoID != 0 {
+			return s.suggestTagsForPhoto(c, req.PhotoID, req.Limit)
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			return newAPIError(400, "missing_field", "missing required field q").WithField("q")
+		}
+		tags, err := s.Queries.SuggestTags(ctx, db.SuggestTagsParams{
+			Query:         req.Query,
+			PrefixPattern: likeEscaper.Replace(req.Query) + "%",
+			RowLimit:      req.Limit,
+		})
+		if err != nil {
+			slog.Error("suggest tags", "error", err)
+			return internalError()
+		}
+		return c.JSON(200, tags)
+	})
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *Server) suggestTagsForPhoto(c echo.Context, photoID, limit int32) error {
+	ctx := c.Request().Context()
+	photo, err := s.Queries.GetPhotoByIDWithTags(ctx, photoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFound("photo not found")
+	} else if err != nil {
+		slog.Error("get photo", "error", err)
+		return internalError()
+	}
+	var photoTags []struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(photo.Tags, &photoTags); err != nil {
+		slog.Error("decode photo tags", "error", err)
+		return internalError()
+	}
+	tagged := make(map[string]bool, len(photoTags))
+	for _, tag := range photoTags {
+		tagged[tag.Title] = true
+	}
+
+	suggestions := []tagSuggestion{}
+	for _, keyword := range metadataKeywords(photo.Metadata["Keywords"]) {
+		suggestion := tagSuggestion{Title: keyword, Source: "keywords"}
+		title, err := s.Queries.ResolveTagTitle(ctx, keyword)
+		if errors.Is(err, pgx.ErrNoRows) {
+			suggestion.New = true
+		} else if err != nil {
+			slog.Error("resolve tag", "error", err)
+			return internalError()
+		} else if tag, err := s.Queries.GetTag(ctx, title); err == nil {
+			suggestion.Title, suggestion.Comment, suggestion.Slug = tag.Title, tag.Comment, tag.Slug
+		} else if errors.Is(err, pgx.ErrNoRows) {
+			continue // in the trash
+		} else {
+			slog.Error("get tag", "error", err)
+			return internalError()
+		}
+		if !tagged[suggestion.Title] {
+			tagged[suggestion.Title] = true
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	similar, err := s.Queries.SuggestTagsForPhoto(ctx, db.SuggestTagsForPhotoParams{
+		PhotoID:  photoID,
+		RowLimit: limit,
+	})
+	if err != nil {
+		slog.Error("suggest tags for photo", "error", err)
+		return internalError()
+	}
+	for _, tag := range similar {
+		if !tagged[tag.Title] {
+			tagged[tag.Title] = true
+			suggestions = append(suggestions, tagSuggestion{
+				Title:   tag.Title,
+				Comment: tag.Comment,
+				Slug:    tag.Slug,
+				Source:  "similar_photos",
+				Score:   tag.Score,
+			})
+		}
+	}
+	return c.JSON(200, suggestions[:min(len(suggestions), int(limit))])
+}
+
+// tag slugs are lowercase words of ascii letters and digits joined by single dashes, like the ones
+// unique_tag_slug (schema.sql) generates.
+var tagSlugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// resolveTagTitle returns the title of the tag with the given slug or title. names that match no
+// tag are returned as is, so callers report them as not found.
+func (s *Server) resolveTagTitle(ctx context.Context, name string) (string, error) {
+	title, err := s.Queries.ResolveTagTitle(ctx, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return name, nil
+	}
+	return title, err
+}
+
+// ResolveTagMiddleware lets routes with a :title param be called with a tag's slug or its title
+// (percent-encoded if it has slashes or other reserved characters). the param is replaced with the
+// tag's title before the handler runs.
+func (s *Server) ResolveTagMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		i := slices.Index(c.ParamNames(), "title")
+		if i == -1 {
+			return next(c)
+		}
+		values := c.ParamValues()
+		title, err := s.resolveTagTitle(c.Request().Context(), unescapedParam(c, values[i]))
+		if err != nil {
+			slog.Error("resolve tag", "error", err)
+			return internalError()
+		}
+		values[i] = title
+		c.SetParamValues(values...)
+		return next(c)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/gen/db"
+)
+
+// formatTree writes nodes as "a(b c(d))".
+func formatTree(nodes []*tagNode) string {
+	var parts []string
+	for _, n := range nodes {
+		if len(n.Children) == 0 {
+			parts = append(parts, n.Title)
+		} else {
+			parts = append(parts, n.Title+"("+formatTree(n.Children)+")")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestTagTree(t *testing.T) {
+	// tag is "title" or "title>parent"; tags are given sorted by title, like ListTagsWithParents
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"empty", nil, ""},
+		{"flat", []string{"a", "b"}, "a b"},
+		{"nested", []string{"asia", "japan>asia", "kyoto>japan", "osaka>japan", "tokyo>japan"}, "asia(japan(kyoto osaka tokyo))"},
+		{"parent sorted after child", []string{"a>z", "b>z", "z"}, "z(a b)"},
+		{"missing parent", []string{"kyoto>japan", "osaka"}, "kyoto osaka"},
+		{"cycle", []string{"a>b", "b>a"}, "b(a)"},
+		{"cycle below a root", []string{"a>c", "b>a", "c>b", "d>c", "root"}, "c(a(b) d) root"},
+		{"own parent", []string{"a>a"}, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rows []db.ListTagsWithParentsRow
+			for _, tag := range tt.tags {
+				title, parent, ok := strings.Cut(tag, ">")
+				rows = append(rows, db.ListTagsWithParentsRow{Title: title, ParentTitle: pgtype.Text{String: parent, Valid: ok}})
+			}
+			if got := formatTree(tagTree(rows)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// slugs are generated by unique_tag_slug (schema.sql); this is the format given slugs are checked
+// against
+func TestTagSlugRegexp(t *testing.T) {
+	for _, slug := range []string{"kyoto", "cafe-tokyo", "cafe-tokyo-2", "2024", "a-b-c"} {
+		if !tagSlugRegexp.MatchString(slug) {
+			t.Errorf("%q isn't a valid slug", slug)
+		}
+	}
+	for _, slug := range []string{"", "Kyoto", "café", "-kyoto", "kyoto-", "cafe--tokyo", "cafe tokyo", "cafe_tokyo", "a/b"} {
+		if tagSlugRegexp.MatchString(slug) {
+			t.Errorf("%q is a valid slug", slug)
+		}
+	}
+}
+
+// slugs are generated by the database (unique_tag_slug), for new and renamed tags
+func TestTagSlugs(t *testing.T) {
+	s := newTestServer(t)
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	slugs := []struct{ title, want string }{
+		{"Café Tokyo " + suffix, "cafe-tokyo-" + suffix},
+		{"cafe tokyo " + suffix, "cafe-tokyo-" + suffix + "-2"},
+		{"CAFÉ/TOKYO!! " + suffix, "cafe-tokyo-" + suffix + "-3"},
+	}
+	for _, tt := range slugs {
+		s.addTestTag(t, tt.title, "")
+		var page struct {
+			Tag db.GetTagRow `json:"tag"`
+		}
+		if err := json.Unmarshal(s.expect(t, 200, http.MethodGet, "/api/v1/tags/"+url.PathEscape(tt.title), nil).Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode tag: %v", err)
+		}
+		if page.Tag.Slug != tt.want {
+			t.Errorf("tag %q has slug %q, want %q", tt.title, page.Tag.Slug, tt.want)
+		}
+	}
+
+	newTitle := "Kyōto " + suffix
+	t.Cleanup(func() { s.Queries.DeleteTagPermanently(context.Background(), newTitle) })
+	var renamed db.UpdateTagRow
+	if err := json.Unmarshal(s.expect(t, 200, http.MethodPatch, "/api/v1/tags/"+url.PathEscape(slugs[0].title), echo.Map{"title": newTitle}).Body.Bytes(), &renamed); err != nil {
+		t.Fatalf("decode renamed tag: %v", err)
+	}
+	if want := "kyoto-" + suffix; renamed.Slug != want {
+		t.Errorf("renamed tag has slug %q, want %q", renamed.Slug, want)
+	}
+}
+
+// cycles are caught by the database (IsTagDescendant)
+func TestTagCycles(t *testing.T) {
+	s := newTestServer(t)
+	// a > b > c
+	a, b, c := unique("cycle-a"), unique("cycle-b"), unique("cycle-c")
+	s.addTestTag(t, a, "")
+	s.addTestTag(t, b, a)
+	s.addTestTag(t, c, b)
+	for _, parent := range []string{a, b, c} {
+		s.expect(t, 409, http.MethodPatch, "/api/v1/tags/"+a, echo.Map{"parent": parent})
+	}
+	s.expect(t, 200, http.MethodPatch, "/api/v1/tags/"+c, echo.Map{"parent": a})
+}
+
+// a tag can't be created over a trashed one, which would lose the trashed tag's posts
+func TestAddTrashedTag(t *testing.T) {
+	s := newTestServer(t)
+	title, slug := unique("trashed"), unique("trashed-tag-post")
+	s.addTestTag(t, title, "")
+	s.addTestPost(t, slug, title)
+
+	s.expect(t, 204, http.MethodDelete, "/api/v1/tags/"+title, nil)
+	s.expect(t, 409, http.MethodPost, "/api/v1/tags", echo.Map{"title": title})
+	s.expect(t, 204, http.MethodPost, "/api/v1/admin/trash/tags/"+title+"/restore", nil)
+	var page struct {
+		PostCount int64 `json:"post_count"`
+	}
+	if err := json.Unmarshal(s.expect(t, 200, http.MethodGet, "/api/v1/tags/"+title, nil).Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode tag: %v", err)
+	}
+	if page.PostCount != 1 {
+		t.Errorf("restored tag has %d posts, want 1", page.PostCount)
+	}
+}
+
+// renaming a tag onto a trashed tag's title conflicts instead of deleting the trashed tag
+func TestRenameOntoTrashedTag(t *testing.T) {
+	s := newTestServer(t)
+	trashed, renamed, slug := unique("trashed"), unique("renamed"), unique("renamed-tag-post")
+	s.addTestTag(t, trashed, "")
+	s.addTestTag(t, renamed, "")
+	s.addTestPost(t, slug, trashed)
+
+	s.expect(t, 204, http.MethodDelete, "/api/v1/tags/"+trashed, nil)
+	s.expect(t, 409, http.MethodPatch, "/api/v1/tags/"+renamed, echo.Map{"title": trashed})
+	s.expect(t, 204, http.MethodPost, "/api/v1/admin/trash/tags/"+trashed+"/restore", nil)
+	if n, err := s.Queries.CountPublishedPostsWithTag(context.Background(), trashed); err != nil || n != 1 {
+		t.Errorf("restored tag has %d posts (%v), want 1", n, err)
+	}
+}
+
+// bulk tagging never recreates or adds trashed tags, whether they're given by title or by slug
+func TestBulkTagTrashedTag(t *testing.T) {
+	s := newTestServer(t)
+	title, slug := unique("trashed"), unique("bulk-tag-post")
+	s.addTestTag(t, title, "")
+	s.addTestPost(t, slug, title)
+	tag, err := s.Queries.GetTag(context.Background(), title)
+	if err != nil {
+		t.Fatalf("get tag: %v", err)
+	}
+
+	s.expect(t, 204, http.MethodDelete, "/api/v1/tags/"+title, nil)
+	for _, name := range []string{title, tag.Slug} {
+		for _, createMissing := range []bool{false, true} {
+			s.expect(t, 409, http.MethodPost, "/api/v1/tags/bulk", echo.Map{"post_slugs": []string{slug}, "add": []string{name}, "create_missing": createMissing})
+		}
+	}
+	s.expect(t, 204, http.MethodPost, "/api/v1/admin/trash/tags/"+title+"/restore", nil)
+	if n, err := s.Queries.CountPublishedPostsWithTag(context.Background(), title); err != nil || n != 1 {
+		t.Errorf("restored tag has %d posts (%v), want 1", n, err)
+	}
+}
+
+// trashed tags can't be added to posts, and trashed posts can't be tagged
+func TestAddTrashedTagToPost(t *testing.T) {
+	s := newTestServer(t)
+	tag, trashed, post := unique("tag"), unique("trashed"), unique("tagged-post")
+	s.addTestTag(t, tag, "")
+	s.addTestTag(t, trashed, "")
+	s.addTestPost(t, post)
+	s.expect(t, 204, http.MethodDelete, "/api/v1/tags/"+trashed, nil)
+
+	s.expect(t, 204, http.MethodPatch, "/api/v1/posts/"+post+"/tag/"+tag, nil)
+	s.expect(t, 204, http.MethodPatch, "/api/v1/posts/"+post+"/tag/"+tag, nil) // already tagged
+	s.expect(t, 404, http.MethodPatch, "/api/v1/posts/"+post+"/tag/"+trashed, nil)
+	s.expect(t, 204, http.MethodDelete, "/api/v1/posts/"+post, nil)
+	s.expect(t, 404, http.MethodPatch, "/api/v1/posts/"+post+"/tag/"+tag, nil)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/ajiteshcc/bucket"
+	"github.com/tiredkangaroo/ajiteshcc/env"
+)
+
+// how often the trash purger checks for items older than TRASH_RETENTION
+const trashPurgeInterval = time.Hour
+
+// GET /api/v1/admin/trash
+func (s *Server) listTrash(c echo.Context) error {
+	ctx := c.Request().Context()
+	photos, err := s.Queries.ListDeletedPhotos(ctx)
+	if err != nil {
+		slog.Error("list deleted photos", "error", err)
+		return internalError()
+	}
+	posts, err := s.Queries.ListDeletedPosts(ctx)
+	if err != nil {
+		slog.Error("list deleted posts", "error", err)
+		return internalError()
+	}
+	tags, err := s.Queries.ListDeletedTags(ctx)
+	if err != nil {
+		slog.Error("list deleted tags", "error", err)
+		return internalError()
+	}
+	objects, err := bucket.ListTrashedObjects(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME)
+	if err != nil {
+		slog.Error("list trashed objects", "error", err)
+		return internalError()
+	}
+	return c.JSON(200, echo.Map{
+		"photos":            photos,
+		"posts":             posts,
+		"tags":              tags,
+		"objects":           objects,
+		"retention_seconds": int64(env.DefaultEnv.TRASH_RETENTION.Seconds()),
+	})
+}
+
+// POST /api/v1/admin/trash/photos/:id/restore
+func (s *Server) restorePhotoHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		ID int32 `param:"id"`
+	}) error {
+		n, err := s.Queries.RestorePhoto(c.Request().Context(), req.ID)
+		if err != nil {
+			slog.Error("restore photo", "error", err)
+			return internalError()
+		} else if n == 0 {
+			return notFound("photo not found in trash")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// POST /api/v1/admin/trash/posts/:slug/restore
+func (s *Server) restorePostHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Slug string `param:"slug"`
+	}) error {
+		n, err := s.Queries.RestorePost(c.Request().Context(), req.Slug)
+		if err != nil {
+			slog.Error("restore post", "error", err)
+			return internalError()
+		} else if n == 0 {
+			return notFound("post not found in trash")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// POST /api/v1/admin/trash/tags/:title/restore
+func (s *Server) restoreTagHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Title string `param:"title"`
+	}) error {
+		n, err := s.Queries.RestoreTag(c.Request().Context(), req.Title)
+		if err != nil {
+			slog.Error("restore tag", "error", err)
+			return internalError()
+		} else if n == 0 {
+			return notFound("tag not found in trash")
+		}
+		return c.NoContent(204)
+	})
+}
+
+// POST /api/v1/admin/trash/objects/:name/restore
+func (s *Server) restoreObjectHandler() echo.HandlerFunc {
+	return handler(func(c echo.Context, req struct {
+		Name string `param:"name"` // original object key (without the trash prefix)
+	}) error {
+		err := bucket.RestoreObject(c.Request().Context(), env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, req.Name)
+		if errors.Is(err, bucket.ErrObjectExists) {
+			return conflict("an object named " + req.Name + " already exists")
+		} else if errors.Is(err, bucket.ErrObjectNotFound) {
+			return notFound("object not found in trash")
+		} else if err != nil {
+			slog.Error("restore object", "error", err)
+			return internalError()
+		}
+		return c.NoContent(204)
+	})
+}
+
+// startTrashPurger permanently deletes trashed items older than TRASH_RETENTION, once at startup
+// and then every trashPurgeInterval, until ctx is cancelled.
+func (s *Server) startTrashPurger(ctx context.Context) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			s.purgeTrash(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) purgeTrash(ctx context.Context) {
+	retention := env.DefaultEnv.TRASH_RETENTION
+	retentionSeconds := int64(retention.Seconds())
+
+	// photos and posts go before tags so their tag associations are removed by the cascade first
+	photos, err := s.Queries.PurgeDeletedPhotos(ctx, retentionSeconds)
+	if err != nil {
+		slog.Error("purge deleted photos", "error", err)
+	}
+	posts, err := s.Queries.PurgeDeletedPosts(ctx, retentionSeconds)
+	if err != nil {
+		slog.Error("purge deleted posts", "error", err)
+	}
+	tags, err := s.Queries.PurgeDeletedTags(ctx, retentionSeconds)
+	if err != nil {
+		slog.Error("purge deleted tags", "error", err)
+	}
+	objects, err := bucket.PurgeTrash(ctx, env.DefaultEnv.R2_PHOTOS_BUCKET_NAME, time.Now().Add(-retention))
+	if err != nil {
+		slog.Error("purge trashed objects", "error", err)
+	}
+	if photos+posts+tags+int64(objects) > 0 {
+		slog.Info("purged trash", "photos", photos, "posts", posts, "tags", tags, "objects", objects)
+	}
+}